@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"birthday/db"
+	"birthday/types"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// createAdminUser makes sure the admin account described by the ADMIN_USER_*
+// variables exists. An existing user with the same email isn't promoted:
+// anybody could have signed up with it before the admin, so the service
+// refuses to start instead.
+func (na *NotifyApp) createAdminUser() error {
+	email := os.Getenv(ADMIN_USER_EMAIL)
+	if email == "" {
+		return nil
+	}
+
+	user, err := na.dbConnection.GetUserByEmail(email)
+	if err == nil {
+		if user.Role == types.RoleAdmin {
+			return nil
+		}
+		return fmt.Errorf("%s belongs to user %d, who isn't an admin", ADMIN_USER_EMAIL, user.ID)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	birthday, err := parseDate(os.Getenv(ADMIN_USER_BIRTHDAY))
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", ADMIN_USER_BIRTHDAY, err)
+	}
+	admin := types.BirthdayUserRequest{
+		BirthdayUserBase: types.BirthdayUserBase{
+			FirstName: os.Getenv(ADMIN_USER_FIRST_NAME),
+			LastName:  os.Getenv(ADMIN_USER_LAST_NAME),
+			Email:     email,
+			Birthday:  birthday,
+		},
+		Password: os.Getenv(ADMIN_USER_PASSWORD),
+	}
+	err = validateBirthdayUser(admin)
+	if err != nil {
+		return fmt.Errorf("invalid admin user: %w", err)
+	}
+	_, err = na.dbConnection.CreateUser(types.BirthdayUser{Role: types.RoleAdmin, EmailVerified: true, BirthdayUserRequest: admin})
+	return err
+}
+
+// parseDate accepts both plain dates and RFC 3339 timestamps.
+func parseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if date, err := time.Parse(time.DateOnly, value); err == nil {
+		return date, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func (na *NotifyApp) adminUserHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, errors.New("invalid user id"))
+		return
+	}
+
+	user, err := na.dbConnection.GetUser(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			respondWithError(w, http.StatusNotFound, err)
+		default:
+			respondWithError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut, http.MethodPatch:
+		na.updateUserHandle(w, r, user)
+	case http.MethodDelete:
+		err = na.dbConnection.DeleteUser(id)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, "deleted user with id "+vars["id"])
+	}
+}
+
+// adminUnlockUserHandler lifts the lockout after too many failed logins to
+// the user's account.
+func (na *NotifyApp) adminUnlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, errors.New("invalid user id"))
+		return
+	}
+
+	err = na.dbConnection.UnlockUser(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(w, http.StatusNotFound, err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "unlocked user with id "+vars["id"])
+}
+
+func (na *NotifyApp) adminGetSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, errors.New("invalid user id"))
+		return
+	}
+
+	users, err := na.dbConnection.GetSubscriptions(id, r)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			respondWithError(w, http.StatusNotFound, err)
+		case errors.Is(err, db.ErrInvalidCursor):
+			respondWithError(w, http.StatusBadRequest, err)
+		default:
+			respondWithError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	respondWithPage(w, r, users)
+}
+
+func (na *NotifyApp) adminSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userId, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, errors.New("invalid user id"))
+		return
+	}
+	id, err := strconv.Atoi(vars["subscriptionId"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, errors.New("invalid subscription id"))
+		return
+	}
+	if userId == id {
+		respondWithError(w, http.StatusBadRequest, errors.New("cannot subscribe to oneself"))
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		err = na.dbConnection.UnSubscribeFromUser(userId, id)
+	} else {
+		err = na.dbConnection.SubscribeToUser(userId, id, nil)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			respondWithError(w, http.StatusNotFound, err)
+		case err.Error() == "already subscribed":
+			respondWithJSON(w, http.StatusOK, "user "+vars["id"]+" is already subscribed to user's birthday with id "+vars["subscriptionId"])
+		default:
+			respondWithError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		respondWithJSON(w, http.StatusOK, "unsubscribed user "+vars["id"]+" from user's birthday with id "+vars["subscriptionId"])
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, "subscribed user "+vars["id"]+" to user's birthday with id "+vars["subscriptionId"])
+}
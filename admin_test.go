@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"birthday/types"
+)
+
+func TestCreateAdminUser(t *testing.T) {
+	app := newTestApp(t)
+	t.Setenv(ADMIN_USER_EMAIL, "admin@example.com")
+	t.Setenv(ADMIN_USER_FIRST_NAME, "Admin")
+	t.Setenv(ADMIN_USER_LAST_NAME, "Admin")
+	t.Setenv(ADMIN_USER_BIRTHDAY, "1980-01-01")
+	t.Setenv(ADMIN_USER_PASSWORD, testPassword)
+	for i := 0; i < 2; i++ {
+		err := app.createAdminUser()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	admin, err := app.db.GetUserByEmail("admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if admin.Role != types.RoleAdmin {
+		t.Errorf("got role %q, want admin", admin.Role)
+	}
+}
+
+func TestCreateAdminUserRefusesRegisteredEmail(t *testing.T) {
+	app := newTestApp(t)
+	id := app.createUser(t, "admin@example.com", time.Date(1990, time.May, 16, 0, 0, 0, 0, time.UTC))
+	t.Setenv(ADMIN_USER_EMAIL, "admin@example.com")
+	t.Setenv(ADMIN_USER_PASSWORD, testPassword)
+
+	err := app.createAdminUser()
+	if err == nil {
+		t.Fatal("promoted a user who signed up with the admin email")
+	}
+	role, err := app.db.GetUserRole(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role != types.RoleUser {
+		t.Errorf("got role %q, want user", role)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"birthday/types"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+const (
+	// apiKeyPrefix tells API keys apart from JWTs in the Authorization
+	// header.
+	apiKeyPrefix        string = "bn_"
+	apiKeyVisibleChars  int    = 8
+	maxAPIKeyNameLength int    = 100
+)
+
+func isAPIKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
+
+// verifyAPIKey turns an API key into the same claims an access token of the
+// user carries, with the scope of the key added.
+func (na *NotifyApp) verifyAPIKey(token string) (jwt.MapClaims, error) {
+	key, err := na.dbConnection.AuthenticateAPIKey(hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	role, err := na.dbConnection.GetUserRole(key.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return jwt.MapClaims{
+		"sub":   float64(key.UserID),
+		"role":  role,
+		"scope": key.Scope,
+		"akid":  float64(key.ID),
+	}, nil
+}
+
+// allowedByScope keeps read-only API keys to the methods that don't change
+// anything. Access tokens carry no scope and may do anything.
+func allowedByScope(claims jwt.MapClaims, method string) bool {
+	if claims["scope"] != types.ScopeRead {
+		return true
+	}
+	return method == http.MethodGet || method == http.MethodHead
+}
+
+func validateAPIKeyRequest(request types.APIKeyRequest) error {
+	var validationErrors []string
+	name := strings.TrimSpace(request.Name)
+	if name == "" || len(name) > maxAPIKeyNameLength {
+		validationErrors = append(validationErrors, "name field is required and must be at most 100 characters long")
+	}
+	if request.Scope != types.ScopeRead && request.Scope != types.ScopeReadWrite {
+		validationErrors = append(validationErrors, "scope must be one of read, read-write")
+	}
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(time.Now()) {
+		validationErrors = append(validationErrors, "expiresAt must be in the future")
+	}
+	if len(validationErrors) > 0 {
+		return errors.New(strings.Join(validationErrors, ", "))
+	}
+	return nil
+}
+
+func (na *NotifyApp) getAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := currentUserId(w, r)
+	if err != nil {
+		return
+	}
+	keys, err := na.dbConnection.GetAPIKeys(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, keys)
+}
+
+func (na *NotifyApp) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := currentUserId(w, r)
+	if err != nil {
+		return
+	}
+	// Otherwise a leaked key could be used to mint keys that outlive it.
+	claims, _ := r.Context().Value(claimsKey).(jwt.MapClaims)
+	if _, err := sessionIdFromClaims(claims); err != nil {
+		respondWithError(w, http.StatusForbidden, errors.New("API keys can only be created with an access token"))
+		return
+	}
+
+	keyRequest := types.APIKeyRequest{Scope: types.ScopeRead}
+	err = json.NewDecoder(r.Body).Decode(&keyRequest)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer r.Body.Close()
+
+	err = validateAPIKeyRequest(keyRequest)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	token = apiKeyPrefix + token
+	key, err := na.dbConnection.CreateAPIKey(types.APIKey{
+		UserID:    userId,
+		Name:      strings.TrimSpace(keyRequest.Name),
+		Prefix:    token[:len(apiKeyPrefix)+apiKeyVisibleChars],
+		KeyHash:   hashToken(token),
+		Scope:     keyRequest.Scope,
+		ExpiresAt: keyRequest.ExpiresAt,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, types.NewAPIKey{APIKey: key, Key: token})
+}
+
+func (na *NotifyApp) revokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := currentUserId(w, r)
+	if err != nil {
+		return
+	}
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, errors.New("invalid API key id"))
+		return
+	}
+
+	err = na.dbConnection.RevokeAPIKey(userId, id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(w, http.StatusNotFound, err)
+			return
+		}
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "revoked API key with id "+vars["id"])
+}
@@ -1,15 +1,18 @@
 package main
 
 import (
+	"birthday/dates"
+	"birthday/db"
 	"birthday/types"
 	"context"
 	"encoding/json"
 	"errors"
-	"os"
+	"fmt"
 	"strings"
 	"time"
 
 	"net/http"
+	"net/url"
 	"regexp"
 	"strconv"
 
@@ -29,16 +32,16 @@ const (
 )
 
 const (
-	emailRegex string = `^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,4}$`
+	emailRegex          string = `^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,4}$`
+	defaultUpcomingDays int    = 7
+	maxUpcomingDays     int    = 366
 )
 
-var jwtSecretKey = []byte(os.Getenv("JWT_SECRET_KEY"))
-
 type ErrorResponse struct {
 	Err string `json:"error"`
 }
 
-func authorizationRequired(h http.Handler) http.Handler {
+func (na *NotifyApp) authorizationRequired(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		tokenString := r.Header.Get("Authorization")
 		if tokenString == "" {
@@ -46,11 +49,15 @@ func authorizationRequired(h http.Handler) http.Handler {
 			return
 		}
 		tokenString = tokenString[len("Bearer "):]
-		claims, err := verifyToken(tokenString)
+		claims, err := na.verifyToken(tokenString)
 		if err != nil {
 			respondWithError(w, http.StatusUnauthorized, err)
 			return
 		}
+		if !allowedByScope(claims, r.Method) {
+			respondWithError(w, http.StatusForbidden, errors.New("API key is read-only"))
+			return
+		}
 
 		ctx := context.WithValue(r.Context(), claimsKey, claims)
 		r = r.WithContext(ctx)
@@ -58,6 +65,19 @@ func authorizationRequired(h http.Handler) http.Handler {
 	})
 }
 
+// roleRequired is authorizationRequired that additionally only lets through
+// users whose token carries the given role.
+func (na *NotifyApp) roleRequired(role string, h http.Handler) http.Handler {
+	return na.authorizationRequired(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := r.Context().Value(claimsKey).(jwt.MapClaims)
+		if claims["role"] != role {
+			respondWithError(w, http.StatusForbidden, errors.New("forbidden"))
+			return
+		}
+		h.ServeHTTP(w, r)
+	}))
+}
+
 func validateBirthdayUser(user types.BirthdayUserRequest) error {
 	var validationErrors []string
 	if user.FirstName == "" {
@@ -75,6 +95,9 @@ func validateBirthdayUser(user types.BirthdayUserRequest) error {
 	if user.Password == "" {
 		validationErrors = append(validationErrors, "password field is required")
 	}
+	if err := validateTimezone(user.Timezone); err != nil {
+		validationErrors = append(validationErrors, err.Error())
+	}
 	if user.Email != "" {
 		re := regexp.MustCompile(emailRegex)
 		if !re.MatchString(user.Email) {
@@ -87,6 +110,16 @@ func validateBirthdayUser(user types.BirthdayUserRequest) error {
 	return nil
 }
 
+func validateTimezone(timezone string) error {
+	if timezone == "" {
+		return nil
+	}
+	if _, err := time.LoadLocation(timezone); err != nil || timezone == "Local" {
+		return errors.New("timezone must be a valid IANA time zone name")
+	}
+	return nil
+}
+
 func respondWithError(w http.ResponseWriter, responseCode int, err error) {
 	respondWithJSON(w, responseCode, ErrorResponse{err.Error()})
 }
@@ -98,14 +131,118 @@ func respondWithJSON(w http.ResponseWriter, reponseCode int, payload any) {
 	w.Write(response)
 }
 
+// respondWithPage writes a listing together with an RFC 8288 Link header
+// pointing at its neighbouring pages. Cursor pages only link forward, numbered
+// pages also link back and to the last page.
+func respondWithPage[T any](w http.ResponseWriter, r *http.Request, page types.Page[T]) {
+	link := func(rel string, params url.Values) string {
+		pageURL := requestURL(r)
+		q := r.URL.Query()
+		q.Del("page")
+		q.Del("cursor")
+		for key, values := range params {
+			q[key] = values
+		}
+		pageURL.RawQuery = q.Encode()
+		return fmt.Sprintf(`<%s>; rel="%s"`, pageURL.String(), rel)
+	}
+
+	var links []string
+	if page.Page == 0 {
+		links = append(links, link("first", url.Values{"cursor": {""}}))
+		if page.NextCursor != "" {
+			links = append(links, link("next", url.Values{"cursor": {page.NextCursor}}))
+		}
+	} else {
+		links = append(links, link("first", nil))
+		if page.Page > 1 {
+			links = append(links, link("prev", url.Values{"page": {strconv.Itoa(page.Page - 1)}}))
+		}
+		if page.NextCursor != "" {
+			links = append(links, link("next", url.Values{"page": {strconv.Itoa(page.Page + 1)}}))
+		}
+		lastPage := max(1, int((page.TotalCount+int64(page.PageSize)-1)/int64(page.PageSize)))
+		links = append(links, link("last", url.Values{"page": {strconv.Itoa(lastPage)}}))
+	}
+	w.Header().Set("Link", strings.Join(links, ", "))
+	respondWithJSON(w, http.StatusOK, page)
+}
+
+// requestURL returns the absolute URL of the request as the client sees it.
+func requestURL(r *http.Request) url.URL {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return url.URL{Scheme: scheme, Host: r.Host, Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+}
+
+func parseUserQuery(r *http.Request) (types.UserQuery, error) {
+	q := r.URL.Query()
+	query := types.UserQuery{
+		Search: strings.TrimSpace(q.Get("q")),
+		SortBy: q.Get("sort"),
+	}
+
+	if q.Get("month") != "" {
+		month, err := strconv.Atoi(q.Get("month"))
+		if err != nil || month < 1 || month > 12 {
+			return types.UserQuery{}, errors.New("month must be a number between 1 and 12")
+		}
+		query.Month = month
+	}
+	for _, bound := range []struct {
+		name  string
+		value *time.Time
+	}{
+		{"birthday_from", &query.BornFrom},
+		{"birthday_to", &query.BornTo},
+	} {
+		if q.Get(bound.name) == "" {
+			continue
+		}
+		date, err := time.Parse(time.DateOnly, q.Get(bound.name))
+		if err != nil {
+			return types.UserQuery{}, fmt.Errorf("%s must be a date in YYYY-MM-DD format", bound.name)
+		}
+		*bound.value = date
+	}
+
+	switch strings.ToLower(q.Get("order")) {
+	case "", "asc":
+	case "desc":
+		query.SortDescending = true
+	default:
+		return types.UserQuery{}, errors.New("order must be asc or desc")
+	}
+	return query, nil
+}
+
 func (na *NotifyApp) getUsersHandler(w http.ResponseWriter, r *http.Request) {
-	users, err := na.dbConnection.GetUsers(r)
+	query, err := parseUserQuery(r)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, err)
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	viewer, err := na.viewer(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err)
+		return
+	}
+	users, err := na.dbConnection.GetUsers(viewer, query, r)
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrInvalidSortField):
+			respondWithError(w, http.StatusBadRequest, errors.New("sort must be one of id, firstName, lastName, birthday"))
+		case errors.Is(err, db.ErrInvalidCursor):
+			respondWithError(w, http.StatusBadRequest, err)
+		default:
+			respondWithError(w, http.StatusInternalServerError, err)
+		}
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, users)
+	respondWithPage(w, r, users)
 }
 
 func (na *NotifyApp) createUsersHandler(w http.ResponseWriter, r *http.Request) {
@@ -126,7 +263,7 @@ func (na *NotifyApp) createUsersHandler(w http.ResponseWriter, r *http.Request)
 	birthdayUser := types.BirthdayUser{BirthdayUserRequest: user}
 	createdUser, err := na.dbConnection.CreateUser(birthdayUser)
 	if err != nil {
-		if err.Error() == "user with this email already exists" {
+		if errors.Is(err, db.ErrEmailTaken) {
 			respondWithError(w, http.StatusBadRequest, err)
 			return
 		}
@@ -134,10 +271,26 @@ func (na *NotifyApp) createUsersHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	na.verifyEmailInBackground(createdUser)
 	respondWithJSON(w, http.StatusCreated, createdUser)
 }
 
-func (na *NotifyApp) putPatchUserHandle(w http.ResponseWriter, r *http.Request, user types.BirthdayUserResponse, id int) {
+func (na *NotifyApp) putPatchUserHandle(w http.ResponseWriter, r *http.Request, user types.BirthdayUserResponse) {
+	userId, err := currentUserId(w, r)
+	if err != nil {
+		return
+	}
+
+	if userId != user.ID {
+		respondWithError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	na.updateUserHandle(w, r, user)
+}
+
+// updateUserHandle updates user and asks to verify the email when it changed.
+func (na *NotifyApp) updateUserHandle(w http.ResponseWriter, r *http.Request, user types.BirthdayUserResponse) {
 	var updatedUser types.BirthdayUserRequest
 	err := json.NewDecoder(r.Body).Decode(&updatedUser)
 	if err != nil {
@@ -148,48 +301,34 @@ func (na *NotifyApp) putPatchUserHandle(w http.ResponseWriter, r *http.Request,
 
 	if r.Method == http.MethodPut {
 		err = validateBirthdayUser(updatedUser)
-		if err != nil {
-			respondWithError(w, http.StatusBadRequest, err)
-			return
-		}
+	} else {
+		err = validateTimezone(updatedUser.Timezone)
 	}
-
-	claims, ok := r.Context().Value(claimsKey).(jwt.MapClaims)
-	if !ok {
-		respondWithError(w, http.StatusInternalServerError, errors.New("missing claims in r.Context. The token may be outdated"))
-		return
-	}
-	subject, ok := claims["sub"]
-	if !ok {
-		respondWithError(w, http.StatusInternalServerError, errors.New("missing subject in JWT map claims. The token may be outdated"))
-		return
-	}
-	idFromSubject, ok := subject.(float64)
-	if !ok {
-		respondWithError(w, http.StatusInternalServerError, errors.New("subject is not a number"))
-		return
-	}
-	userId := int(idFromSubject)
-
-	if userId != user.ID {
-		respondWithError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
 		return
 	}
 
 	if r.Method == http.MethodPut {
-		updatedUser, err := na.dbConnection.UpdateUser(id, updatedUser)
+		updatedUser, err := na.dbConnection.UpdateUser(user.ID, updatedUser)
 		if err != nil {
 			respondWithError(w, http.StatusInternalServerError, err)
 			return
 		}
+		if updatedUser.Email != user.Email {
+			na.verifyEmailInBackground(updatedUser)
+		}
 		respondWithJSON(w, http.StatusCreated, updatedUser)
 		return
 	} else if r.Method == http.MethodPatch {
-		patchedUser, err := na.dbConnection.PatchUser(id, updatedUser)
+		patchedUser, err := na.dbConnection.PatchUser(user.ID, updatedUser)
 		if err != nil {
 			respondWithError(w, http.StatusInternalServerError, err)
 			return
 		}
+		if patchedUser.Email != user.Email {
+			na.verifyEmailInBackground(patchedUser)
+		}
 		respondWithJSON(w, http.StatusOK, patchedUser)
 		return
 	}
@@ -213,12 +352,99 @@ func (na *NotifyApp) getUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if r.Method == http.MethodPut || r.Method == http.MethodPatch {
-		na.putPatchUserHandle(w, r, user, id)
+		na.putPatchUserHandle(w, r, user)
+		return
+	}
+
+	viewer, err := na.viewer(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err)
+		return
+	}
+	user, err = na.dbConnection.GetVisibleUser(viewer, id)
+	if err != nil {
+		switch err {
+		case gorm.ErrRecordNotFound:
+			respondWithError(w, http.StatusNotFound, err)
+		default:
+			respondWithError(w, http.StatusInternalServerError, err)
+		}
 		return
 	}
 	respondWithJSON(w, http.StatusOK, user)
 }
 
+// viewer identifies who the response is for. Routes open to anonymous callers
+// still honour a valid Authorization header, so that subscribers and admins
+// see what privacy settings allow them to.
+func (na *NotifyApp) viewer(r *http.Request) (types.Viewer, error) {
+	claims, ok := r.Context().Value(claimsKey).(jwt.MapClaims)
+	if !ok {
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			return types.Viewer{}, nil
+		}
+		var err error
+		claims, err = na.verifyToken(strings.TrimPrefix(header, "Bearer "))
+		if err != nil {
+			return types.Viewer{}, err
+		}
+	}
+	subject, _ := claims["sub"].(float64)
+	return types.Viewer{ID: int(subject), Admin: claims["role"] == types.RoleAdmin}, nil
+}
+
+func isAdmin(r *http.Request) bool {
+	claims, _ := r.Context().Value(claimsKey).(jwt.MapClaims)
+	return claims["role"] == types.RoleAdmin
+}
+
+func (na *NotifyApp) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := currentUserId(w, r)
+	if err != nil {
+		return
+	}
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, errors.New("invalid user id"))
+		return
+	}
+
+	if userId != id && !isAdmin(r) {
+		respondWithError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return
+	}
+
+	err = na.dbConnection.DeleteUser(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			respondWithError(w, http.StatusNotFound, err)
+		default:
+			respondWithError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, "deleted user with id "+vars["id"])
+}
+
+func (na *NotifyApp) exportUserDataHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := currentUserId(w, r)
+	if err != nil {
+		return
+	}
+	export, err := na.dbConnection.GetUserDataExport(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="user-%d-export.json"`, userId))
+	respondWithJSON(w, http.StatusOK, export)
+}
+
 func subscribeUnsubscribeBase(w http.ResponseWriter, r *http.Request, vars map[string]string) (int, int, error) {
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
@@ -257,11 +483,18 @@ func (na *NotifyApp) subscribeToUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = na.dbConnection.SubscribeToUser(userId, id)
+	settings, err := decodeSubscriptionSettings(w, r)
+	if err != nil {
+		return
+	}
+
+	requested, err := na.dbConnection.RequestSubscription(userId, id, settings.ReminderOffsets)
 	if err != nil {
 		switch {
 		case errors.Is(err, gorm.ErrRecordNotFound):
 			respondWithError(w, http.StatusNotFound, err)
+		case errors.Is(err, db.ErrSubscriptionRequested):
+			respondWithJSON(w, http.StatusOK, "subscription to user's birthday with id "+vars["id"]+" is already waiting for approval")
 		case err.Error() == "already subscribed":
 			respondWithJSON(w, http.StatusOK, "already subscribed to user's birthday with id "+vars["id"])
 		default:
@@ -270,6 +503,10 @@ func (na *NotifyApp) subscribeToUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	if requested {
+		respondWithJSON(w, http.StatusAccepted, "requested subscription to user's birthday with id "+vars["id"]+", waiting for approval")
+		return
+	}
 	respondWithJSON(w, http.StatusCreated, "subscribed to user's birthday with id "+vars["id"])
 }
 
@@ -294,7 +531,7 @@ func (na *NotifyApp) unsubscribeFromUserHandler(w http.ResponseWriter, r *http.R
 	respondWithJSON(w, http.StatusCreated, "unsubscribed from user's birthday with id "+vars["id"])
 }
 
-func birthdaysSubscriptionsBase(w http.ResponseWriter, r *http.Request) (int, error) {
+func currentUserId(w http.ResponseWriter, r *http.Request) (int, error) {
 	claims, ok := r.Context().Value(claimsKey).(jwt.MapClaims)
 	if !ok {
 		respondWithError(w, http.StatusInternalServerError, errors.New("missing claims in r.Context"))
@@ -315,11 +552,77 @@ func birthdaysSubscriptionsBase(w http.ResponseWriter, r *http.Request) (int, er
 }
 
 func (na *NotifyApp) getBirthdaysHandler(w http.ResponseWriter, r *http.Request) {
-	userId, err := birthdaysSubscriptionsBase(w, r)
+	userId, err := currentUserId(w, r)
 	if err != nil {
 		return
 	}
 	users, err := na.dbConnection.GetBirthdays(userId, r)
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrInvalidCursor):
+			respondWithError(w, http.StatusBadRequest, err)
+		default:
+			respondWithError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	respondWithPage(w, r, users)
+}
+
+func parseUpcomingWindow(r *http.Request, today time.Time) (time.Time, time.Time, error) {
+	q := r.URL.Query()
+	days := defaultUpcomingDays
+	if q.Get("days") != "" {
+		var err error
+		days, err = strconv.Atoi(q.Get("days"))
+		if err != nil || days < 0 || days > maxUpcomingDays {
+			return time.Time{}, time.Time{}, fmt.Errorf("days must be a number between 0 and %d", maxUpcomingDays)
+		}
+	}
+
+	from := today
+	if q.Get("from") != "" {
+		var err error
+		from, err = time.Parse(time.DateOnly, q.Get("from"))
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("from must be a date in YYYY-MM-DD format")
+		}
+	}
+	to := from.AddDate(0, 0, days)
+	if q.Get("to") != "" {
+		var err error
+		to, err = time.Parse(time.DateOnly, q.Get("to"))
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("to must be a date in YYYY-MM-DD format")
+		}
+	}
+	if to.Before(from) {
+		return time.Time{}, time.Time{}, errors.New("to must not be before from")
+	}
+	if dates.DaysBetween(from, to) > maxUpcomingDays {
+		return time.Time{}, time.Time{}, fmt.Errorf("the window must not be longer than %d days", maxUpcomingDays)
+	}
+	return from, to, nil
+}
+
+func (na *NotifyApp) getUpcomingBirthdaysHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := currentUserId(w, r)
+	if err != nil {
+		return
+	}
+	user, err := na.dbConnection.GetUser(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	today := dates.Today(user.Timezone)
+	from, to, err := parseUpcomingWindow(r, today)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	users, err := na.dbConnection.GetUpcomingBirthdays(userId, today, from, to)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, err)
 		return
@@ -329,17 +632,22 @@ func (na *NotifyApp) getBirthdaysHandler(w http.ResponseWriter, r *http.Request)
 }
 
 func (na *NotifyApp) getSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
-	userId, err := birthdaysSubscriptionsBase(w, r)
+	userId, err := currentUserId(w, r)
 	if err != nil {
 		return
 	}
 	users, err := na.dbConnection.GetSubscriptions(userId, r)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, err)
+		switch {
+		case errors.Is(err, db.ErrInvalidCursor):
+			respondWithError(w, http.StatusBadRequest, err)
+		default:
+			respondWithError(w, http.StatusInternalServerError, err)
+		}
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, users)
+	respondWithPage(w, r, users)
 }
 
 func (na *NotifyApp) getTokenhandler(w http.ResponseWriter, r *http.Request) {
@@ -349,41 +657,67 @@ func (na *NotifyApp) getTokenhandler(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusBadRequest, err)
 		return
 	}
+	ip := na.clientIP(r)
+	if na.loginBlocked(w, loginData.Email, ip) {
+		return
+	}
+
+	// Unknown emails and wrong passwords get the same response, so that it
+	// doesn't tell which emails have accounts.
 	user, err := na.dbConnection.GetUserByEmail(loginData.Email)
 	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			respondWithError(w, http.StatusNotFound, errors.New("User not found"))
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			respondWithError(w, http.StatusInternalServerError, err)
+			return
+		}
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(loginData.Password))
+	} else {
+		err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(loginData.Password))
+	}
+	if err != nil {
+		err = na.dbConnection.RecordLoginFailure(loginData.Email, ip)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, err)
 			return
 		}
-		respondWithError(w, http.StatusInternalServerError, err)
+		respondWithError(w, http.StatusUnauthorized, errInvalidCredentials)
 		return
 	}
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(loginData.Password))
+	err = na.dbConnection.ClearLoginFailures(loginData.Email)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, errors.New("incorrect password"))
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if na.requireVerifiedEmail && !user.EmailVerified {
+		respondWithError(w, http.StatusForbidden, errors.New("email address is not verified"))
 		return
 	}
 
-	payload := jwt.MapClaims{
-		"sub": user.ID,
-		"exp": time.Now().Add(time.Hour * 24).Unix(),
+	refreshToken, err := generateToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	session, err := na.dbConnection.CreateSession(user.ID, hashToken(refreshToken), time.Now().Add(refreshTokenTTL))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, payload)
-	t, err := token.SignedString(jwtSecretKey)
+	t, err := na.signAccessToken(user.ID, session.ID, user.Role)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, errors.New("JWT token signing"))
 		return
 	}
 
-	respondWithJSON(w, http.StatusCreated, types.Token{Token: t})
+	respondWithJSON(w, http.StatusCreated, types.Token{Token: t, RefreshToken: refreshToken})
 }
 
-func verifyToken(tokenString string) (jwt.MapClaims, error) {
-	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
-		return jwtSecretKey, nil
-	})
-
+func (na *NotifyApp) verifyToken(tokenString string) (jwt.MapClaims, error) {
+	if isAPIKey(tokenString) {
+		return na.verifyAPIKey(tokenString)
+	}
+	token, err := na.jwtKeys.parse(tokenString)
 	if err != nil {
 		return nil, err
 	}
@@ -397,6 +731,18 @@ func verifyToken(tokenString string) (jwt.MapClaims, error) {
 		return nil, errors.New("invalid token claims")
 	}
 
+	sessionId, err := sessionIdFromClaims(claims)
+	if err != nil {
+		return nil, err
+	}
+	active, err := na.dbConnection.IsSessionActive(sessionId)
+	if err != nil {
+		return nil, err
+	}
+	if !active {
+		return nil, errors.New("session has been revoked")
+	}
+
 	return claims, nil
 }
 
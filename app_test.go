@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"birthday/db"
+	"birthday/types"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const testPassword = "Passw0rd!x"
+
+func TestMain(m *testing.M) {
+	db.PasswordHashCost = bcrypt.MinCost
+	os.Exit(m.Run())
+}
+
+// sentEmail is an email the app tried to send during a test.
+type sentEmail struct {
+	to, subject, text string
+}
+
+// recordingMailer keeps the emails instead of sending them. They are sent
+// from goroutines, so wait for them with next.
+type recordingMailer struct {
+	emails chan sentEmail
+}
+
+func (m recordingMailer) Send(to, subject, text, html string) error {
+	m.emails <- sentEmail{to: to, subject: subject, text: text}
+	return nil
+}
+
+func (m recordingMailer) next(t *testing.T, to, subject string) sentEmail {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case email := <-m.emails:
+			if email.to == to && email.subject == subject {
+				return email
+			}
+		case <-timeout:
+			t.Fatalf("no %q email was sent to %s", subject, to)
+		}
+	}
+}
+
+// token returns the single-use token an email carries.
+func (email sentEmail) token(t *testing.T) string {
+	t.Helper()
+	_, rest, found := strings.Cut(email.text, "The token is:\n\n")
+	if !found {
+		t.Fatalf("no token in %q", email.text)
+	}
+	token, _, _ := strings.Cut(rest, "\n")
+	return token
+}
+
+type testApp struct {
+	*NotifyApp
+	db     db.DataBase
+	mailer recordingMailer
+}
+
+// newTestApp runs the whole API against an in-memory database of its own.
+func newTestApp(t *testing.T) *testApp {
+	t.Helper()
+	database, err := db.NewMemoryDataBase(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	database.DB = database.DB.Session(&gorm.Session{Logger: logger.Discard})
+	sqlDB, err := database.DB.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	err = database.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(JWT_SECRET_KEY_ENV, "test secret")
+	keys, err := newJWTKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mailer := recordingMailer{emails: make(chan sentEmail, 100)}
+	app := &testApp{
+		NotifyApp: &NotifyApp{Router: mux.NewRouter(), dbConnection: database, mailer: mailer, jwtKeys: keys},
+		db:        database,
+		mailer:    mailer,
+	}
+	app.setupRoutes()
+	return app
+}
+
+func (app *testApp) request(t *testing.T, method, path, token string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var payload bytes.Buffer
+	if body != nil {
+		err := json.NewEncoder(&payload).Encode(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := httptest.NewRequest(method, path, &payload)
+	r.RemoteAddr = "192.0.2.1:1234"
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	app.Router.ServeHTTP(w, r)
+	return w
+}
+
+func decode[T any](t *testing.T, w *httptest.ResponseRecorder) T {
+	t.Helper()
+	var value T
+	err := json.Unmarshal(w.Body.Bytes(), &value)
+	if err != nil {
+		t.Fatalf("decoding %s: %v", w.Body.String(), err)
+	}
+	return value
+}
+
+func expectStatus(t *testing.T, w *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("got status %d, want %d: %s", w.Code, status, w.Body.String())
+	}
+}
+
+func (app *testApp) createUser(t *testing.T, email string, birthday time.Time) int {
+	t.Helper()
+	w := app.request(t, http.MethodPost, "/api/users", "", map[string]any{
+		"firstName": strings.Split(email, "@")[0],
+		"lastName":  "Test",
+		"email":     email,
+		"birthday":  birthday,
+		"password":  testPassword,
+		"timezone":  "UTC",
+	})
+	expectStatus(t, w, http.StatusCreated)
+	return decode[types.BirthdayUserResponse](t, w).ID
+}
+
+func (app *testApp) login(t *testing.T, email string) string {
+	t.Helper()
+	w := app.request(t, http.MethodPost, "/api/auth/token", "", types.LoginRequest{Email: email, Password: testPassword})
+	expectStatus(t, w, http.StatusCreated)
+	return decode[types.Token](t, w).Token
+}
+
+func TestUserLifecycle(t *testing.T) {
+	app := newTestApp(t)
+	birthday := time.Date(1990, time.May, 16, 0, 0, 0, 0, time.UTC)
+	id := app.createUser(t, "ann@example.com", birthday)
+
+	w := app.request(t, http.MethodPost, "/api/users", "", map[string]any{
+		"firstName": "Other", "lastName": "Ann", "email": "ann@example.com", "birthday": birthday, "password": testPassword,
+	})
+	expectStatus(t, w, http.StatusBadRequest)
+
+	token := app.login(t, "ann@example.com")
+	path := "/api/users/" + strconv.Itoa(id)
+	w = app.request(t, http.MethodPatch, path, token, map[string]any{"lastName": "Changed"})
+	expectStatus(t, w, http.StatusOK)
+
+	w = app.request(t, http.MethodGet, path, "", nil)
+	expectStatus(t, w, http.StatusOK)
+	user := decode[types.BirthdayUserResponse](t, w)
+	if user.LastName != "Changed" || !user.Birthday.Equal(birthday) {
+		t.Errorf("got %+v after PATCH", user)
+	}
+
+	w = app.request(t, http.MethodGet, "/api/users", "", nil)
+	expectStatus(t, w, http.StatusOK)
+	if page := decode[types.Page[types.BirthdayUserResponse]](t, w); page.TotalCount != 1 || len(page.Items) != 1 {
+		t.Errorf("got page %+v, want the one user", page)
+	}
+
+	w = app.request(t, http.MethodDelete, path, token, nil)
+	expectStatus(t, w, http.StatusOK)
+	w = app.request(t, http.MethodGet, path, "", nil)
+	expectStatus(t, w, http.StatusNotFound)
+	w = app.request(t, http.MethodPost, "/api/auth/token", "", types.LoginRequest{Email: "ann@example.com", Password: testPassword})
+	expectStatus(t, w, http.StatusUnauthorized)
+}
+
+func TestUsersCannotUpdateOthers(t *testing.T) {
+	app := newTestApp(t)
+	app.createUser(t, "ann@example.com", time.Date(1990, time.May, 16, 0, 0, 0, 0, time.UTC))
+	bob := app.createUser(t, "bob@example.com", time.Date(1991, time.June, 1, 0, 0, 0, 0, time.UTC))
+	token := app.login(t, "ann@example.com")
+
+	w := app.request(t, http.MethodPatch, "/api/users/"+strconv.Itoa(bob), token, map[string]any{"lastName": "Changed"})
+	expectStatus(t, w, http.StatusUnauthorized)
+	w = app.request(t, http.MethodDelete, "/api/users/"+strconv.Itoa(bob), token, nil)
+	expectStatus(t, w, http.StatusUnauthorized)
+}
+
+func TestAuthorizationRequired(t *testing.T) {
+	app := newTestApp(t)
+	for _, token := range []string{"", "not-a-jwt", "bn_not-a-key"} {
+		w := app.request(t, http.MethodGet, "/api/subscriptions", token, nil)
+		expectStatus(t, w, http.StatusUnauthorized)
+	}
+}
+
+func TestBirthdaysOfSubscriptions(t *testing.T) {
+	app := newTestApp(t)
+	today := time.Now().UTC()
+	bornToday := time.Date(2000, today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)
+	bornTomorrow := bornToday.AddDate(0, 0, 1)
+	app.createUser(t, "ann@example.com", time.Date(1990, time.May, 16, 0, 0, 0, 0, time.UTC))
+	bob := app.createUser(t, "bob@example.com", bornToday)
+	carol := app.createUser(t, "carol@example.com", bornTomorrow)
+	app.createUser(t, "dave@example.com", bornToday)
+	token := app.login(t, "ann@example.com")
+
+	for _, id := range []int{bob, carol} {
+		w := app.request(t, http.MethodPost, "/api/users/"+strconv.Itoa(id)+"/subscribe", token, nil)
+		expectStatus(t, w, http.StatusCreated)
+	}
+	w := app.request(t, http.MethodPost, "/api/users/"+strconv.Itoa(bob)+"/subscribe", token, nil)
+	expectStatus(t, w, http.StatusOK)
+
+	w = app.request(t, http.MethodGet, "/api/subscriptions", token, nil)
+	expectStatus(t, w, http.StatusOK)
+	if page := decode[types.Page[types.BirthdayUserResponse]](t, w); page.TotalCount != 2 {
+		t.Errorf("got %d subscriptions, want 2", page.TotalCount)
+	}
+
+	w = app.request(t, http.MethodGet, "/api/birthdays", token, nil)
+	expectStatus(t, w, http.StatusOK)
+	birthdays := decode[types.Page[types.BirthdayUserResponse]](t, w)
+	if len(birthdays.Items) != 1 || birthdays.Items[0].ID != bob {
+		t.Errorf("got birthdays %+v, want only bob's", birthdays.Items)
+	}
+
+	w = app.request(t, http.MethodPost, "/api/users/"+strconv.Itoa(bob)+"/unsubscribe", token, nil)
+	expectStatus(t, w, http.StatusCreated)
+	w = app.request(t, http.MethodGet, "/api/birthdays", token, nil)
+	expectStatus(t, w, http.StatusOK)
+	if birthdays := decode[types.Page[types.BirthdayUserResponse]](t, w); len(birthdays.Items) != 0 {
+		t.Errorf("got birthdays %+v after unsubscribing", birthdays.Items)
+	}
+}
+
+func TestExportStreamsEveryUser(t *testing.T) {
+	app := newTestApp(t)
+	app.createUser(t, "ann@example.com", time.Date(1990, time.May, 16, 0, 0, 0, 0, time.UTC))
+	token := app.login(t, "ann@example.com")
+
+	// More than one batch of the streaming export.
+	const users = 600
+	for i := 0; i < users; i++ {
+		_, err := app.db.CreateUser(types.BirthdayUser{BirthdayUserRequest: types.BirthdayUserRequest{
+			BirthdayUserBase: types.BirthdayUserBase{
+				FirstName: "User", LastName: strconv.Itoa(i), Email: fmt.Sprintf("user%d@example.com", i),
+				Birthday: time.Date(1990, time.May, 16, 0, 0, 0, 0, time.UTC),
+			},
+			Password: testPassword,
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := app.request(t, http.MethodGet, "/api/users/export?format=ndjson", token, nil)
+	expectStatus(t, w, http.StatusOK)
+	ids := map[int]bool{}
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		var user types.BirthdayUserResponse
+		err := json.Unmarshal(scanner.Bytes(), &user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[user.ID] = true
+	}
+	if len(ids) != users+1 {
+		t.Errorf("exported %d distinct users, want %d", len(ids), users+1)
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	app := newTestApp(t)
+	id := app.createUser(t, "ann@example.com", time.Date(1990, time.May, 16, 0, 0, 0, 0, time.UTC))
+	token := app.login(t, "ann@example.com")
+	w := app.request(t, http.MethodPost, "/api/auth/keys", token, map[string]any{"name": "script"})
+	expectStatus(t, w, http.StatusCreated)
+	apiKey := decode[types.NewAPIKey](t, w).Key
+	w = app.request(t, http.MethodGet, "/api/subscriptions", apiKey, nil)
+	expectStatus(t, w, http.StatusOK)
+
+	w = app.request(t, http.MethodPost, "/api/auth/password/forgot", "", types.ForgotPasswordRequest{Email: "ann@example.com"})
+	expectStatus(t, w, http.StatusAccepted)
+	resetToken := app.mailer.next(t, "ann@example.com", "Reset your password").token(t)
+	w = app.request(t, http.MethodPost, "/api/auth/password/reset", "", types.ResetPasswordRequest{Token: resetToken, Password: "N3w-Passw0rd!"})
+	expectStatus(t, w, http.StatusOK)
+	w = app.request(t, http.MethodGet, "/api/subscriptions", apiKey, nil)
+	expectStatus(t, w, http.StatusUnauthorized)
+	w = app.request(t, http.MethodPost, "/api/auth/password/reset", "", types.ResetPasswordRequest{Token: resetToken, Password: "An0ther-Passw0rd!"})
+	expectStatus(t, w, http.StatusBadRequest)
+	w = app.request(t, http.MethodGet, "/api/subscriptions", token, nil)
+	expectStatus(t, w, http.StatusUnauthorized)
+
+	verified, err := app.db.IsEmailVerified(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified {
+		t.Error("resetting the password verified the email")
+	}
+}
+
+func TestEmailChangeInvalidatesPasswordReset(t *testing.T) {
+	app := newTestApp(t)
+	id := app.createUser(t, "ann@example.com", time.Date(1990, time.May, 16, 0, 0, 0, 0, time.UTC))
+	token := app.login(t, "ann@example.com")
+
+	w := app.request(t, http.MethodPost, "/api/auth/password/forgot", "", types.ForgotPasswordRequest{Email: "ann@example.com"})
+	expectStatus(t, w, http.StatusAccepted)
+	resetToken := app.mailer.next(t, "ann@example.com", "Reset your password").token(t)
+	w = app.request(t, http.MethodPatch, "/api/users/"+strconv.Itoa(id), token, map[string]any{"email": "ann@example.org"})
+	expectStatus(t, w, http.StatusOK)
+	w = app.request(t, http.MethodPost, "/api/auth/password/reset", "", types.ResetPasswordRequest{Token: resetToken, Password: "N3w-Passw0rd!"})
+	expectStatus(t, w, http.StatusBadRequest)
+}
+
+func TestPasswordResetThrottled(t *testing.T) {
+	app := newTestApp(t)
+	app.createUser(t, "ann@example.com", time.Date(1990, time.May, 16, 0, 0, 0, 0, time.UTC))
+
+	for i := 0; i < 6; i++ {
+		w := app.request(t, http.MethodPost, "/api/auth/password/reset", "", types.ResetPasswordRequest{Token: "guess" + strconv.Itoa(i), Password: "N3w-Passw0rd!"})
+		expectStatus(t, w, http.StatusBadRequest)
+	}
+	w := app.request(t, http.MethodPost, "/api/auth/password/reset", "", types.ResetPasswordRequest{Token: "guess", Password: "N3w-Passw0rd!"})
+	expectStatus(t, w, http.StatusTooManyRequests)
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("no Retry-After header")
+	}
+	w = app.request(t, http.MethodPost, "/api/auth/password/forgot", "", types.ForgotPasswordRequest{Email: "ann@example.com"})
+	expectStatus(t, w, http.StatusTooManyRequests)
+	// Logins aren't throttled by password resets.
+	app.login(t, "ann@example.com")
+}
+
+// TestUnverifiedUserCanVerify follows a user who may not log in before
+// verifying their email and has lost the first token.
+func TestUnverifiedUserCanVerify(t *testing.T) {
+	app := newTestApp(t)
+	app.requireVerifiedEmail = true
+	app.createUser(t, "ann@example.com", time.Date(1990, time.May, 16, 0, 0, 0, 0, time.UTC))
+	app.mailer.next(t, "ann@example.com", "Confirm your email address")
+
+	w := app.request(t, http.MethodPost, "/api/auth/token", "", types.LoginRequest{Email: "ann@example.com", Password: testPassword})
+	expectStatus(t, w, http.StatusForbidden)
+
+	w = app.request(t, http.MethodPost, "/api/auth/email/resend", "", types.ResendVerificationRequest{Email: "nobody@example.com"})
+	expectStatus(t, w, http.StatusAccepted)
+	unknown := w.Body.String()
+	w = app.request(t, http.MethodPost, "/api/auth/email/resend", "", types.ResendVerificationRequest{Email: "ann@example.com"})
+	expectStatus(t, w, http.StatusAccepted)
+	if w.Body.String() != unknown {
+		t.Errorf("answered %s for an account and %s for an unknown email", w.Body.String(), unknown)
+	}
+	token := app.mailer.next(t, "ann@example.com", "Confirm your email address").token(t)
+
+	w = app.request(t, http.MethodPost, "/api/auth/email/verify", "", types.VerifyEmailRequest{Token: token})
+	expectStatus(t, w, http.StatusOK)
+	app.login(t, "ann@example.com")
+
+	for i := 0; i < 4; i++ {
+		w = app.request(t, http.MethodPost, "/api/auth/email/resend", "", types.ResendVerificationRequest{Email: "ann@example.com"})
+		expectStatus(t, w, http.StatusAccepted)
+	}
+	w = app.request(t, http.MethodPost, "/api/auth/email/resend", "", types.ResendVerificationRequest{Email: "ann@example.com"})
+	expectStatus(t, w, http.StatusTooManyRequests)
+}
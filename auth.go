@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"birthday/db"
+	"birthday/types"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	accessTokenTTL  time.Duration = 15 * time.Minute
+	refreshTokenTTL time.Duration = 30 * 24 * time.Hour
+)
+
+func (na *NotifyApp) signAccessToken(userId, sessionId int, role string) (string, error) {
+	now := time.Now()
+	return na.jwtKeys.sign(jwt.MapClaims{
+		"sub":  userId,
+		"sid":  sessionId,
+		"role": role,
+		"iat":  now.Unix(),
+		"exp":  now.Add(accessTokenTTL).Unix(),
+	})
+}
+
+func generateToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken hashes high-entropy random tokens before they are stored. Unlike
+// passwords they don't need a slow hash like bcrypt.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func sessionIdFromClaims(claims jwt.MapClaims) (int, error) {
+	sid, ok := claims["sid"].(float64)
+	if !ok {
+		return 0, errors.New("missing session in JWT map claims. The token may be outdated")
+	}
+	return int(sid), nil
+}
+
+func (na *NotifyApp) refreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var refreshRequest types.RefreshRequest
+	err := json.NewDecoder(r.Body).Decode(&refreshRequest)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer r.Body.Close()
+
+	if refreshRequest.RefreshToken == "" {
+		respondWithError(w, http.StatusBadRequest, errors.New("refresh_token field is required"))
+		return
+	}
+
+	refreshToken, err := generateToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	session, err := na.dbConnection.RotateRefreshToken(hashToken(refreshRequest.RefreshToken), hashToken(refreshToken), time.Now().Add(refreshTokenTTL))
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrInvalidRefreshToken), errors.Is(err, db.ErrRefreshTokenReused):
+			respondWithError(w, http.StatusUnauthorized, err)
+		default:
+			respondWithError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	role, err := na.dbConnection.GetUserRole(session.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	t, err := na.signAccessToken(session.UserID, session.ID, role)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, errors.New("JWT token signing"))
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, types.Token{Token: t, RefreshToken: refreshToken})
+}
+
+func (na *NotifyApp) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := currentUserId(w, r)
+	if err != nil {
+		return
+	}
+	claims, _ := r.Context().Value(claimsKey).(jwt.MapClaims)
+	if _, ok := claims["akid"]; ok {
+		respondWithError(w, http.StatusBadRequest, errors.New("API keys have no session to log out of, revoke the key instead"))
+		return
+	}
+	sessionId, err := sessionIdFromClaims(claims)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	err = na.dbConnection.RevokeSession(userId, sessionId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, "logged out")
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"birthday/calendar"
+	"birthday/types"
+
+	"gorm.io/gorm"
+)
+
+const maxReminderDays int = 30
+
+func (na *NotifyApp) createCalendarTokenHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := currentUserId(w, r)
+	if err != nil {
+		return
+	}
+	token, err := generateToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	// Only the hash is stored, so every call issues a new URL and
+	// invalidates the previous one.
+	err = na.dbConnection.SetCalendarToken(userId, hashToken(token))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	feedURL := requestURL(r)
+	feedURL.Path = "/api/subscriptions/calendar.ics"
+	feedURL.RawQuery = url.Values{"token": {token}}.Encode()
+	respondWithJSON(w, http.StatusCreated, types.CalendarFeed{URL: feedURL.String(), Token: token})
+}
+
+// calendarUserId authenticates the feed request either with the usual
+// Authorization header or with the secret token from the feed URL, which is
+// all most calendar clients can send.
+func (na *NotifyApp) calendarUserId(r *http.Request) (int, error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		claims, err := na.verifyToken(strings.TrimPrefix(header, "Bearer "))
+		if err != nil {
+			return 0, err
+		}
+		subject, ok := claims["sub"].(float64)
+		if !ok {
+			return 0, errors.New("subject is not a number")
+		}
+		return int(subject), nil
+	}
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		return 0, errors.New("missing auth token")
+	}
+	userId, err := na.dbConnection.GetUserIdByCalendarToken(hashToken(token))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, errors.New("invalid calendar token")
+		}
+		return 0, err
+	}
+	return userId, nil
+}
+
+func (na *NotifyApp) calendarFeedHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := na.calendarUserId(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	reminderDays := -1
+	if value := r.URL.Query().Get("reminder_days"); value != "" {
+		reminderDays, err = strconv.Atoi(value)
+		if err != nil || reminderDays < 0 || reminderDays > maxReminderDays {
+			respondWithError(w, http.StatusBadRequest, fmt.Errorf("reminder_days must be a number between 0 and %d", maxReminderDays))
+			return
+		}
+	}
+
+	users, err := na.dbConnection.GetAllSubscriptions(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	feed := calendar.Feed{
+		Name:          "Birthdays",
+		UIDPrefix:     strconv.Itoa(userId),
+		LeapDayPolicy: na.leapDayPolicy,
+		ReminderDays:  reminderDays,
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="birthdays.ics"`)
+	w.WriteHeader(http.StatusOK)
+	err = feed.Write(w, users)
+	if err != nil {
+		log.Printf("calendar feed of user %d: %v\n", userId, err)
+	}
+}
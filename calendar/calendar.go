@@ -0,0 +1,119 @@
+package calendar
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"birthday/dates"
+	"birthday/types"
+)
+
+const (
+	productId  = "-//birthday_notify//Birthday calendar//EN"
+	maxLineLen = 75
+	// yearlessStart is the year events of birthdays with a hidden year start
+	// in. It is a leap year, so leap day birthdays keep their date.
+	yearlessStart = 2000
+)
+
+// Feed renders an RFC 5545 calendar with a yearly all-day event for every
+// birthday.
+type Feed struct {
+	Name          string
+	UIDPrefix     string
+	LeapDayPolicy dates.LeapDayPolicy
+	// ReminderDays adds a display alarm that many days before each birthday
+	// when not negative.
+	ReminderDays int
+}
+
+func (f Feed) Write(w io.Writer, users []types.BirthdayUserResponse) error {
+	lw := &lineWriter{w: w}
+	lw.line("BEGIN:VCALENDAR")
+	lw.line("VERSION:2.0")
+	lw.line("PRODID:" + productId)
+	lw.line("CALSCALE:GREGORIAN")
+	lw.line("METHOD:PUBLISH")
+	lw.line("X-WR-CALNAME:" + escape(f.Name))
+
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	for _, user := range users {
+		name := strings.TrimSpace(user.FirstName + " " + user.LastName)
+		lw.line("BEGIN:VEVENT")
+		lw.line(fmt.Sprintf("UID:%s-%d@birthday-notify", f.UIDPrefix, user.ID))
+		lw.line("DTSTAMP:" + stamp)
+		start := user.Birthday
+		if user.BirthYearHidden() {
+			start = time.Date(yearlessStart, start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+		}
+		lw.line("DTSTART;VALUE=DATE:" + start.Format("20060102"))
+		lw.line("RRULE:" + f.recurrence(start))
+		lw.line("SUMMARY:" + escape(name+"'s birthday"))
+		if user.Email != "" {
+			lw.line("DESCRIPTION:" + escape("Email: "+user.Email))
+		}
+		lw.line("TRANSP:TRANSPARENT")
+		if f.ReminderDays >= 0 {
+			lw.line("BEGIN:VALARM")
+			lw.line("ACTION:DISPLAY")
+			lw.line("DESCRIPTION:" + escape(name+"'s birthday"))
+			lw.line(fmt.Sprintf("TRIGGER:-P%dD", f.ReminderDays))
+			lw.line("END:VALARM")
+		}
+		lw.line("END:VEVENT")
+	}
+	lw.line("END:VCALENDAR")
+	return lw.err
+}
+
+// recurrence expresses the leap day policy in the RRULE: the last day of
+// February falls on the 28th in common years and the 60th day of the year on
+// 1 March, while both are 29 February in leap years.
+func (f Feed) recurrence(born time.Time) string {
+	if born.Month() != time.February || born.Day() != 29 {
+		return "FREQ=YEARLY"
+	}
+	switch f.LeapDayPolicy {
+	case dates.LeapDayMar1:
+		return "FREQ=YEARLY;BYYEARDAY=60"
+	case dates.LeapDayLeapYearsOnly:
+		return "FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=29"
+	default:
+		return "FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=-1"
+	}
+}
+
+func escape(text string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(text)
+}
+
+// lineWriter terminates content lines with CRLF and folds them at 75
+// octets without splitting UTF-8 sequences.
+type lineWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (lw *lineWriter) line(content string) {
+	if lw.err != nil {
+		return
+	}
+	var b strings.Builder
+	limit := maxLineLen
+	for len(content) > limit {
+		cut := limit
+		for cut > 0 && content[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(content[:cut])
+		b.WriteString("\r\n ")
+		content = content[cut:]
+		// continuation lines start with a space that counts towards the limit
+		limit = maxLineLen - 1
+	}
+	b.WriteString(content)
+	b.WriteString("\r\n")
+	_, lw.err = io.WriteString(lw.w, b.String())
+}
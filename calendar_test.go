@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// brokenConnection is a response writer whose client went away.
+type brokenConnection struct {
+	header http.Header
+}
+
+func (c brokenConnection) Header() http.Header {
+	return c.header
+}
+
+func (brokenConnection) Write([]byte) (int, error) {
+	return 0, errors.New("connection reset by peer")
+}
+
+func (brokenConnection) WriteHeader(int) {}
+
+func TestCalendarFeedLogsWriteErrors(t *testing.T) {
+	app := newTestApp(t)
+	ann := app.createUser(t, "ann@example.com", time.Date(1990, time.May, 1, 0, 0, 0, 0, time.UTC))
+	bob := app.createUser(t, "bob@example.com", time.Date(1990, time.May, 16, 0, 0, 0, 0, time.UTC))
+	err := app.db.SubscribeToUser(ann, bob, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := app.login(t, "ann@example.com")
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	r := httptest.NewRequest(http.MethodGet, "/api/subscriptions/calendar.ics", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	app.Router.ServeHTTP(brokenConnection{header: http.Header{}}, r)
+	if !strings.Contains(logs.String(), "connection reset by peer") {
+		t.Errorf("logged %q, want the write error", logs.String())
+	}
+}
@@ -0,0 +1,113 @@
+package dates
+
+import (
+	"fmt"
+	"time"
+)
+
+// LeapDayPolicy decides when people born on 29 February celebrate in
+// non-leap years.
+type LeapDayPolicy string
+
+const (
+	LeapDayFeb28         LeapDayPolicy = "feb28"
+	LeapDayMar1          LeapDayPolicy = "mar1"
+	LeapDayLeapYearsOnly LeapDayPolicy = "leap-only"
+)
+
+func ParseLeapDayPolicy(s string) (LeapDayPolicy, error) {
+	switch policy := LeapDayPolicy(s); policy {
+	case "":
+		return LeapDayFeb28, nil
+	case LeapDayFeb28, LeapDayMar1, LeapDayLeapYearsOnly:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown leap day policy %q, expected one of %s, %s, %s", s, LeapDayFeb28, LeapDayMar1, LeapDayLeapYearsOnly)
+	}
+}
+
+type MonthDay struct {
+	Month time.Month
+	Day   int
+}
+
+// Day truncates t to midnight UTC of its calendar date, so that dates from
+// different locations can be compared and subtracted safely.
+func Day(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// Location resolves an IANA time zone name. Users without a valid time zone
+// fall back to the server's local time zone.
+func Location(timezone string) *time.Location {
+	if timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// Today returns the current date in the given time zone.
+func Today(timezone string) time.Time {
+	return Day(time.Now().In(Location(timezone)))
+}
+
+func IsLeapYear(year int) bool {
+	return year%4 == 0 && (year%100 != 0 || year%400 == 0)
+}
+
+func isLeapDay(born time.Time) bool {
+	return born.Month() == time.February && born.Day() == 29
+}
+
+// Occurrence returns the date the birthday is celebrated on in the given
+// year, or false if it is not celebrated that year at all.
+func (p LeapDayPolicy) Occurrence(born time.Time, year int) (time.Time, bool) {
+	if !isLeapDay(born) || IsLeapYear(year) {
+		return time.Date(year, born.Month(), born.Day(), 0, 0, 0, 0, time.UTC), true
+	}
+	switch p {
+	case LeapDayMar1:
+		return time.Date(year, time.March, 1, 0, 0, 0, 0, time.UTC), true
+	case LeapDayLeapYearsOnly:
+		return time.Time{}, false
+	default:
+		return time.Date(year, time.February, 28, 0, 0, 0, 0, time.UTC), true
+	}
+}
+
+// NextBirthday returns the first birthday celebrated on or after from.
+func (p LeapDayPolicy) NextBirthday(born, from time.Time) time.Time {
+	from = Day(from)
+	for year := from.Year(); ; year++ {
+		next, ok := p.Occurrence(born, year)
+		if ok && !next.Before(from) {
+			return next
+		}
+	}
+}
+
+// BirthDatesOn returns the dates of birth whose birthday is celebrated on day.
+func (p LeapDayPolicy) BirthDatesOn(day time.Time) []MonthDay {
+	birthDates := []MonthDay{{day.Month(), day.Day()}}
+	if IsLeapYear(day.Year()) {
+		return birthDates
+	}
+	leapDay := MonthDay{time.February, 29}
+	switch {
+	case p == LeapDayMar1 && day.Month() == time.March && day.Day() == 1:
+		birthDates = append(birthDates, leapDay)
+	case (p == LeapDayFeb28 || p == "") && day.Month() == time.February && day.Day() == 28:
+		birthDates = append(birthDates, leapDay)
+	}
+	return birthDates
+}
+
+// DaysBetween returns the number of calendar days from one date to another.
+func DaysBetween(from, to time.Time) int {
+	return int(Day(to).Sub(Day(from)).Hours() / 24)
+}
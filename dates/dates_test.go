@@ -0,0 +1,127 @@
+package dates
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+var policies = []LeapDayPolicy{LeapDayFeb28, LeapDayMar1, LeapDayLeapYearsOnly}
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestOccurrence(t *testing.T) {
+	leapDay := date(2000, time.February, 29)
+	tests := []struct {
+		born time.Time
+		year int
+		// want is indexed like policies, a zero time means no birthday.
+		want [3]time.Time
+	}{
+		{leapDay, 2024, [3]time.Time{date(2024, time.February, 29), date(2024, time.February, 29), date(2024, time.February, 29)}},
+		{leapDay, 2023, [3]time.Time{date(2023, time.February, 28), date(2023, time.March, 1), {}}},
+		{leapDay, 2100, [3]time.Time{date(2100, time.February, 28), date(2100, time.March, 1), {}}},
+		{leapDay, 2000, [3]time.Time{date(2000, time.February, 29), date(2000, time.February, 29), date(2000, time.February, 29)}},
+		{date(1999, time.February, 28), 2023, [3]time.Time{date(2023, time.February, 28), date(2023, time.February, 28), date(2023, time.February, 28)}},
+		{date(1999, time.March, 1), 2024, [3]time.Time{date(2024, time.March, 1), date(2024, time.March, 1), date(2024, time.March, 1)}},
+	}
+	for _, tt := range tests {
+		for i, policy := range policies {
+			got, ok := policy.Occurrence(tt.born, tt.year)
+			if want := tt.want[i]; ok != !want.IsZero() || !got.Equal(want) {
+				t.Errorf("%s: born %s, in %d got %s, %t, want %s", policy, tt.born.Format(time.DateOnly), tt.year, got.Format(time.DateOnly), ok, want.Format(time.DateOnly))
+			}
+		}
+	}
+}
+
+func TestBirthDatesOn(t *testing.T) {
+	feb28, feb29, mar1 := MonthDay{time.February, 28}, MonthDay{time.February, 29}, MonthDay{time.March, 1}
+	tests := []struct {
+		day  time.Time
+		want [3][]MonthDay
+	}{
+		{date(2023, time.February, 28), [3][]MonthDay{{feb28, feb29}, {feb28}, {feb28}}},
+		{date(2023, time.March, 1), [3][]MonthDay{{mar1}, {mar1, feb29}, {mar1}}},
+		{date(2024, time.February, 28), [3][]MonthDay{{feb28}, {feb28}, {feb28}}},
+		{date(2024, time.February, 29), [3][]MonthDay{{feb29}, {feb29}, {feb29}}},
+		{date(2024, time.March, 1), [3][]MonthDay{{mar1}, {mar1}, {mar1}}},
+		{date(2100, time.February, 28), [3][]MonthDay{{feb28, feb29}, {feb28}, {feb28}}},
+		{date(2000, time.February, 28), [3][]MonthDay{{feb28}, {feb28}, {feb28}}},
+	}
+	for _, tt := range tests {
+		for i, policy := range policies {
+			if got := policy.BirthDatesOn(tt.day); !slices.Equal(got, tt.want[i]) {
+				t.Errorf("%s: on %s got %v, want %v", policy, tt.day.Format(time.DateOnly), got, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestNextBirthday(t *testing.T) {
+	leapDay := date(2000, time.February, 29)
+	tests := []struct {
+		from time.Time
+		want [3]time.Time
+	}{
+		{date(2023, time.January, 10), [3]time.Time{date(2023, time.February, 28), date(2023, time.March, 1), date(2024, time.February, 29)}},
+		{date(2023, time.March, 1), [3]time.Time{date(2024, time.February, 29), date(2023, time.March, 1), date(2024, time.February, 29)}},
+		{date(2024, time.February, 29), [3]time.Time{date(2024, time.February, 29), date(2024, time.February, 29), date(2024, time.February, 29)}},
+		{date(2024, time.March, 1), [3]time.Time{date(2025, time.February, 28), date(2025, time.March, 1), date(2028, time.February, 29)}},
+		{date(2097, time.March, 2), [3]time.Time{date(2098, time.February, 28), date(2098, time.March, 1), date(2104, time.February, 29)}},
+	}
+	for _, tt := range tests {
+		for i, policy := range policies {
+			if got := policy.NextBirthday(leapDay, tt.from); !got.Equal(tt.want[i]) {
+				t.Errorf("%s: from %s got %s, want %s", policy, tt.from.Format(time.DateOnly), got.Format(time.DateOnly), tt.want[i].Format(time.DateOnly))
+			}
+		}
+	}
+}
+
+// TestLeapDayAcrossTimeZones checks whether someone born on 29 February is
+// celebrated at one instant, which is a different calendar day depending on
+// the time zone.
+func TestLeapDayAcrossTimeZones(t *testing.T) {
+	feb29 := MonthDay{time.February, 29}
+	tests := []struct {
+		instant  time.Time
+		timezone string
+		// want is indexed like policies.
+		want [3]bool
+	}{
+		// 20:00 UTC on 28 February 2023 is already 1 March in Vladivostok.
+		{time.Date(2023, time.February, 28, 20, 0, 0, 0, time.UTC), "Europe/Lisbon", [3]bool{true, false, false}},
+		{time.Date(2023, time.February, 28, 20, 0, 0, 0, time.UTC), "Asia/Vladivostok", [3]bool{false, true, false}},
+		{time.Date(2023, time.February, 28, 20, 0, 0, 0, time.UTC), "America/Los_Angeles", [3]bool{true, false, false}},
+		// 05:00 UTC on 1 March 2023 is still 28 February in Los Angeles.
+		{time.Date(2023, time.March, 1, 5, 0, 0, 0, time.UTC), "America/Los_Angeles", [3]bool{true, false, false}},
+		{time.Date(2023, time.March, 1, 5, 0, 0, 0, time.UTC), "Europe/Lisbon", [3]bool{false, true, false}},
+		// 20:00 UTC on 29 February 2024 is already 1 March in Vladivostok.
+		{time.Date(2024, time.February, 29, 20, 0, 0, 0, time.UTC), "Europe/Lisbon", [3]bool{true, true, true}},
+		{time.Date(2024, time.February, 29, 20, 0, 0, 0, time.UTC), "Asia/Vladivostok", [3]bool{false, false, false}},
+		{time.Date(2024, time.February, 28, 20, 0, 0, 0, time.UTC), "Asia/Vladivostok", [3]bool{true, true, true}},
+	}
+	for _, tt := range tests {
+		day := Day(tt.instant.In(Location(tt.timezone)))
+		for i, policy := range policies {
+			if got := slices.Contains(policy.BirthDatesOn(day), feb29); got != tt.want[i] {
+				t.Errorf("%s: at %s in %s (%s) celebrated %t, want %t", policy, tt.instant.Format(time.RFC3339), tt.timezone, day.Format(time.DateOnly), got, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestParseLeapDayPolicy(t *testing.T) {
+	for value, want := range map[string]LeapDayPolicy{"": LeapDayFeb28, "feb28": LeapDayFeb28, "mar1": LeapDayMar1, "leap-only": LeapDayLeapYearsOnly} {
+		got, err := ParseLeapDayPolicy(value)
+		if err != nil || got != want {
+			t.Errorf("ParseLeapDayPolicy(%q) = %q, %v, want %q", value, got, err, want)
+		}
+	}
+	if _, err := ParseLeapDayPolicy("feb29"); err == nil {
+		t.Error("accepted an unknown policy")
+	}
+}
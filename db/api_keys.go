@@ -0,0 +1,69 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"birthday/types"
+
+	"gorm.io/gorm"
+)
+
+// lastUsedResolution limits how often using a key writes its last-used
+// timestamp, scripts may make many requests a minute.
+const lastUsedResolution time.Duration = time.Minute
+
+var ErrInvalidAPIKey = errors.New("invalid or expired API key")
+
+func (db DataBase) CreateAPIKey(key types.APIKey) (types.APIKey, error) {
+	err := db.DB.Create(&key).Error
+	if err != nil {
+		return types.APIKey{}, err
+	}
+	return key, nil
+}
+
+func (db DataBase) GetAPIKeys(userId int) ([]types.APIKey, error) {
+	keys := []types.APIKey{}
+	err := db.DB.Where("user_id = ?", userId).Order("id ASC").Find(&keys).Error
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (db DataBase) RevokeAPIKey(userId, id int) error {
+	result := db.DB.Where("user_id = ?", userId).Delete(&types.APIKey{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// AuthenticateAPIKey returns the unexpired key with the hash and records
+// that it was used.
+func (db DataBase) AuthenticateAPIKey(keyHash string) (types.APIKey, error) {
+	var key types.APIKey
+	err := db.DB.Where("key_hash = ?", keyHash).First(&key).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return types.APIKey{}, ErrInvalidAPIKey
+		}
+		return types.APIKey{}, err
+	}
+	now := time.Now()
+	if key.ExpiresAt != nil && now.After(*key.ExpiresAt) {
+		return types.APIKey{}, ErrInvalidAPIKey
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= lastUsedResolution {
+		err = db.DB.Model(&key).Update("last_used_at", now).Error
+		if err != nil {
+			return types.APIKey{}, err
+		}
+	}
+	return key, nil
+}
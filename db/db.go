@@ -4,13 +4,19 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"runtime"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"birthday/dates"
 	"birthday/types"
 
-	"github.com/joho/godotenv"
+	"github.com/glebarez/sqlite"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 
@@ -20,82 +26,337 @@ import (
 const (
 	MANY_TO_MANY_FIELD                       string = "Subscriptions"
 	THROUGH_MANY_TO_MANY_TABLE_SECOND_COLUMN string = "subscription_id"
+	SUBSCRIPTIONS_TABLE                      string = "user_subscriptions"
+	POSTGRES_DRIVER                          string = "postgres"
+	SQLITE_DRIVER                            string = "sqlite"
+	MEMORY_DRIVER                            string = "memory"
+	DEFAULT_SQLITE_PATH                      string = "birthday.db"
+	MEMORY_DATABASE_NAME                     string = "birthday"
 )
 
+// PasswordHashCost is the bcrypt cost passwords are hashed with. Tests lower
+// it to keep creating users fast.
+var PasswordHashCost = 14
+
 type DataBase struct {
-	DB *gorm.DB
+	DB            *gorm.DB
+	LeapDayPolicy dates.LeapDayPolicy
+	// RequireVerifiedEmail leaves users who haven't verified their email out
+	// of notifications.
+	RequireVerifiedEmail bool
 }
 
-func ConnectToDb(dbHost, dbUser, dbPass, dbName, dbPort, connectionUrl string) (DataBase, error) {
-	err := godotenv.Load()
-	if err != nil {
-		return DataBase{}, fmt.Errorf("error loading .env file: %w", err)
+func ConnectToDb(dbDriver, dbHost, dbUser, dbPass, dbName, dbPort, sqlitePath, connectionUrl string) (DataBase, error) {
+	var dialector gorm.Dialector
+	switch driver := os.Getenv(dbDriver); driver {
+	case "", POSTGRES_DRIVER:
+		dbConnectionUrl := fmt.Sprintf(connectionUrl, os.Getenv(dbHost), os.Getenv(dbUser), os.Getenv(dbPass), os.Getenv(dbName), os.Getenv(dbPort))
+		dialector = postgres.Open(dbConnectionUrl)
+	case SQLITE_DRIVER:
+		path := os.Getenv(sqlitePath)
+		if path == "" {
+			path = DEFAULT_SQLITE_PATH
+		}
+		dialector = sqlite.Open(path)
+	case MEMORY_DRIVER:
+		return NewMemoryDataBase(MEMORY_DATABASE_NAME)
+	default:
+		return DataBase{}, fmt.Errorf("unknown database driver %q", driver)
 	}
-	dbConnectionUrl := fmt.Sprintf(connectionUrl, os.Getenv(dbHost), os.Getenv(dbUser), os.Getenv(dbPass), os.Getenv(dbName), os.Getenv(dbPort))
-	db, err := gorm.Open(postgres.Open(dbConnectionUrl), &gorm.Config{})
+	return open(dialector)
+}
+
+// NewMemoryDataBase opens an in-memory SQLite database, which lives until
+// its last connection is closed. Databases with different names are
+// independent, which lets tests each start from an empty one.
+func NewMemoryDataBase(name string) (DataBase, error) {
+	return open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared", url.QueryEscape(name))))
+}
+
+func open(dialector gorm.Dialector) (DataBase, error) {
+	db, err := gorm.Open(dialector, &gorm.Config{})
 	if err != nil {
 		return DataBase{}, fmt.Errorf("error openning a database connection: %w", err)
 	}
+	if dialector.Name() == SQLITE_DRIVER {
+		// SQLite allows a single writer, serialize access instead of failing
+		// with "database is locked".
+		sqlDB, err := db.DB()
+		if err != nil {
+			return DataBase{}, err
+		}
+		sqlDB.SetMaxOpenConns(1)
+	}
 	return DataBase{DB: db}, nil
 }
 
+// datePart returns an SQL expression extracting the month or day number of
+// a date column in the current SQL dialect.
+func (db DataBase) datePart(part, column string) string {
+	if db.DB.Dialector.Name() == SQLITE_DRIVER {
+		format := map[string]string{"MONTH": "%m", "DAY": "%d"}[part]
+		return fmt.Sprintf("CAST(strftime('%s', %s) AS INTEGER)", format, column)
+	}
+	return fmt.Sprintf("EXTRACT(%s FROM %s)", part, column)
+}
+
+// monthDay returns an SQL expression formatting a date column as MM-DD, which
+// sorts birthdays in calendar order regardless of the year.
+func (db DataBase) monthDay(column string) string {
+	if db.DB.Dialector.Name() == SQLITE_DRIVER {
+		return fmt.Sprintf("strftime('%%m-%%d', %s)", column)
+	}
+	return fmt.Sprintf("to_char(%s, 'MM-DD')", column)
+}
+
+// birthdayOn returns a condition matching birthdays in column that are
+// celebrated on day according to the leap day policy.
+func (db DataBase) birthdayOn(column string, day time.Time) (string, []any) {
+	var conditions []string
+	var args []any
+	for _, birthDate := range db.LeapDayPolicy.BirthDatesOn(day) {
+		conditions = append(conditions, "("+db.datePart("MONTH", column)+" = ? AND "+db.datePart("DAY", column)+" = ?)")
+		args = append(args, int(birthDate.Month), birthDate.Day)
+	}
+	return "(" + strings.Join(conditions, " OR ") + ")", args
+}
+
+func (db DataBase) Migrate() error {
+	err := db.DB.SetupJoinTable(&types.BirthdayUser{}, MANY_TO_MANY_FIELD, &types.UserSubscription{})
+	if err != nil {
+		return err
+	}
+	return db.DB.AutoMigrate(&types.BirthdayUser{}, &types.Notification{}, &types.Webhook{}, &types.WebhookDelivery{}, &types.Session{}, &types.RefreshToken{}, &types.SubscriptionRequest{}, &types.Group{}, &types.GroupMember{}, &types.GroupSubscription{}, &types.DigestDelivery{}, &types.PasswordResetToken{}, &types.EmailVerificationToken{}, &types.LoginThrottle{}, &types.APIKey{})
+}
+
 func Paginate(r *http.Request) func(db *gorm.DB) *gorm.DB {
 	return func(db *gorm.DB) *gorm.DB {
-		q := r.URL.Query()
-		page, _ := strconv.Atoi(q.Get("page"))
-		if page <= 0 {
-			page = 1
+		page, pageSize := pageNumbers(r)
+		offset := (page - 1) * pageSize
+		return db.Offset(offset).Limit(pageSize)
+	}
+}
+
+var ErrInvalidSortField = errors.New("invalid sort field")
+
+// userSortKey is the allow-list of sortable fields, user input is never put
+// into ORDER BY directly. Birthdays sort by month and day, not by year.
+func (db DataBase) userSortKey(field string) (string, bool) {
+	switch field {
+	case "", "id":
+		return "", true
+	case "firstName":
+		return "LOWER(first_name)", true
+	case "lastName":
+		return "LOWER(last_name)", true
+	case "birthday":
+		return db.monthDay("birthday"), true
+	default:
+		return "", false
+	}
+}
+
+// filterUsers narrows the users down by the query. Only what the privacy
+// settings let the viewer see is matched against: hidden emails aren't
+// searched, and birthdays whose year is hidden are compared by month and day
+// alone, matching when they fall into the range in some year.
+func (db DataBase) filterUsers(query types.UserQuery, viewer types.Viewer) func(tx *gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		if query.Search != "" {
+			pattern := "%" + likeEscaper.Replace(strings.ToLower(query.Search)) + "%"
+			emailVisible, args := visibleUnless("birthday_users.hide_email", viewer)
+			tx = tx.Where("(LOWER(first_name) LIKE ? ESCAPE '\\' OR LOWER(last_name) LIKE ? ESCAPE '\\' OR ("+emailVisible+" AND LOWER(email) LIKE ? ESCAPE '\\'))",
+				append([]any{pattern, pattern}, append(args, pattern)...)...)
+		}
+		if query.Month != 0 {
+			tx = tx.Where(db.datePart("MONTH", "birthday")+" = ?", query.Month)
+		}
+		if query.BornFrom.IsZero() && query.BornTo.IsZero() {
+			return tx
 		}
 
-		pageSize, _ := strconv.Atoi(q.Get("page_size"))
-		switch {
-		case pageSize > 100:
-			pageSize = 100
-		case pageSize <= 0:
-			pageSize = 10
+		var conditions []string
+		var args []any
+		if !query.BornFrom.IsZero() {
+			conditions = append(conditions, "birthday >= ?")
+			args = append(args, query.BornFrom)
 		}
+		if !query.BornTo.IsZero() {
+			conditions = append(conditions, "birthday < ?")
+			args = append(args, query.BornTo.AddDate(0, 0, 1))
+		}
+		if viewer.Admin {
+			return tx.Where(strings.Join(conditions, " AND "), args...)
+		}
+		yearVisible, yearArgs := visibleUnless("birthday_users.hide_birth_year", viewer)
+		monthDayMatches, monthDayArgs := db.monthDayBetween(query.BornFrom, query.BornTo)
+		args = append(append(append(yearArgs, args...), yearArgs...), monthDayArgs...)
+		return tx.Where("(("+yearVisible+" AND "+strings.Join(conditions, " AND ")+") OR (NOT "+yearVisible+" AND "+monthDayMatches+"))", args...)
+	}
+}
 
-		offset := (page - 1) * pageSize
-		return db.Offset(offset).Limit(pageSize)
+// monthDayBetween returns a condition matching the birthdays that fall
+// between from and to in some year. Either of them may be zero.
+func (db DataBase) monthDayBetween(from, to time.Time) (string, []any) {
+	switch {
+	case from.IsZero() || to.IsZero():
+		return "TRUE", nil
+	case to.Before(from):
+		return "FALSE", nil
+	case !to.Before(from.AddDate(1, 0, -1)):
+		return "TRUE", nil
+	}
+	fromMonthDay, toMonthDay := from.Format("01-02"), to.Format("01-02")
+	monthDay := db.monthDay("birthday")
+	if fromMonthDay > toMonthDay {
+		return "(" + monthDay + " >= ? OR " + monthDay + " <= ?)", []any{fromMonthDay, toMonthDay}
 	}
+	return "(" + monthDay + " BETWEEN ? AND ?)", []any{fromMonthDay, toMonthDay}
 }
 
-func (db DataBase) GetUsers(r *http.Request) ([]types.BirthdayUserResponse, error) {
-	var user types.BirthdayUser
-	var usersResponse []types.BirthdayUserResponse
-	err := db.DB.Model(&user).Scopes(Paginate(r)).Order("id ASC").Find(&usersResponse).Error
-	if err != nil {
-		return nil, err
+// visibleUnless returns a condition telling whether the viewer may see what
+// the privacy setting in column hides.
+func visibleUnless(column string, viewer types.Viewer) (string, []any) {
+	if viewer.Admin {
+		return "TRUE", nil
+	}
+	return "(NOT " + column + " OR birthday_users.id = ?)", []any{viewer.ID}
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func (db DataBase) GetUsers(viewer types.Viewer, query types.UserQuery, r *http.Request) (types.Page[types.BirthdayUserResponse], error) {
+	sortKey, ok := db.userSortKey(query.SortBy)
+	if !ok {
+		return types.Page[types.BirthdayUserResponse]{}, ErrInvalidSortField
 	}
-	return usersResponse, nil
+	order := userOrder{sortBy: query.SortBy, sortKey: sortKey, descending: query.SortDescending}
+	return db.paginateUsers(db.DB.Model(&types.BirthdayUser{}).Scopes(db.filterUsers(query, viewer)), order, viewer, r)
 }
 
+var ErrEmailTaken = errors.New("user with this email already exists")
+
 func (db DataBase) CreateUser(user types.BirthdayUser) (types.BirthdayUserResponse, error) {
-	var userCheck types.BirthdayUser
-	emailCheck := db.DB.Where("email = ?", user.Email).First(&userCheck)
-	if emailCheck.RowsAffected > 0 {
-		return types.BirthdayUserResponse{}, errors.New("user with this email already exists")
+	if db.emailTaken(db.DB, user.Email) {
+		return types.BirthdayUserResponse{}, ErrEmailTaken
 	}
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), 14)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), PasswordHashCost)
 	if err != nil {
 		return types.BirthdayUserResponse{}, err
 	}
 	user.Password = string(hashedPassword)
-	err = db.DB.Create(&user).Error
+	return db.createUser(db.DB, user)
+}
+
+func (db DataBase) emailTaken(tx *gorm.DB, email string) bool {
+	var userCheck types.BirthdayUser
+	emailCheck := tx.Where("email = ?", email).Limit(1).Find(&userCheck)
+	return emailCheck.RowsAffected > 0
+}
+
+// createUser inserts a user whose password is already hashed.
+func (db DataBase) createUser(tx *gorm.DB, user types.BirthdayUser) (types.BirthdayUserResponse, error) {
+	err := tx.Create(&user).Error
 	if err != nil {
 		return types.BirthdayUserResponse{}, err
 	}
 	return types.BirthdayUserResponse{
-		ID: user.ID,
-		BirthdayUserBase: types.BirthdayUserBase{
-			FirstName: user.FirstName,
-			LastName:  user.LastName,
-			Email:     user.Email,
-			Birthday:  user.Birthday,
-		},
+		ID:               user.ID,
+		BirthdayUserBase: user.BirthdayUserBase,
 	}, nil
 }
 
+// ImportUsers creates users in a single transaction, each row behind its own
+// savepoint so a failing row doesn't affect the others. In a dry run, or when
+// atomic is set and any row failed, the transaction is rolled back at the end.
+func (db DataBase) ImportUsers(users []types.BirthdayUser, dryRun, atomic bool) ([]types.ImportResult, error) {
+	results := make([]types.ImportResult, len(users))
+	if !dryRun {
+		err := hashPasswords(users)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	errRollback := errors.New("rollback")
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		failed := false
+		for i, user := range users {
+			results[i].Email = user.Email
+			if db.emailTaken(tx, user.Email) {
+				results[i].Status = types.ImportStatusFailed
+				results[i].Error = ErrEmailTaken.Error()
+				failed = true
+				continue
+			}
+
+			err := tx.SavePoint("import_row").Error
+			if err != nil {
+				return err
+			}
+			created, err := db.createUser(tx, user)
+			if err != nil {
+				if rollbackErr := tx.RollbackTo("import_row").Error; rollbackErr != nil {
+					return rollbackErr
+				}
+				results[i].Status = types.ImportStatusFailed
+				results[i].Error = err.Error()
+				failed = true
+				continue
+			}
+			results[i].ID = created.ID
+			results[i].Status = types.ImportStatusCreated
+		}
+		if dryRun || (atomic && failed) {
+			return errRollback
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errRollback) {
+		return nil, err
+	}
+
+	if errors.Is(err, errRollback) {
+		for i := range results {
+			results[i].ID = 0
+			if results[i].Status != types.ImportStatusCreated {
+				continue
+			}
+			results[i].Status = types.ImportStatusSkipped
+			if dryRun {
+				results[i].Status = types.ImportStatusValid
+			}
+		}
+	}
+	return results, nil
+}
+
+// hashPasswords hashes the passwords of users in place, in parallel since
+// every bcrypt hash takes a noticeable amount of time.
+func hashPasswords(users []types.BirthdayUser) error {
+	errs := make([]error, len(users))
+	semaphore := make(chan struct{}, runtime.NumCPU())
+	var wg sync.WaitGroup
+	for i := range users {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(user *types.BirthdayUser, err *error) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			hashedPassword, hashErr := bcrypt.GenerateFromPassword([]byte(user.Password), PasswordHashCost)
+			if hashErr != nil {
+				*err = hashErr
+				return
+			}
+			user.Password = string(hashedPassword)
+		}(&users[i], &errs[i])
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
 func (db DataBase) GetUserByEmail(email string) (types.BirthdayUser, error) {
 	var userCheck types.BirthdayUser
 	err := db.DB.Where("email = ?", email).First(&userCheck).Error
@@ -115,7 +376,20 @@ func (db DataBase) GetUser(id int) (types.BirthdayUserResponse, error) {
 	return userResponse, nil
 }
 
-func (db DataBase) SubscribeToUser(userThatSubscibesId, userToSubscribeid int) error {
+// GetVisibleUser is GetUser as seen by viewer. Users hidden from the viewer
+// are reported as not found, so their existence isn't revealed either.
+func (db DataBase) GetVisibleUser(viewer types.Viewer, id int) (types.BirthdayUserResponse, error) {
+	var userResponse types.BirthdayUserResponse
+	err := db.DB.Model(&types.BirthdayUser{}).Scopes(visibleTo(viewer)).First(&userResponse, id).Error
+	if err != nil {
+		return types.BirthdayUserResponse{}, err
+	}
+	return viewer.Redact(userResponse), nil
+}
+
+// SubscribeToUser subscribes with the given reminder offsets, or with a
+// reminder on the day itself when there are none.
+func (db DataBase) SubscribeToUser(userThatSubscibesId, userToSubscribeid int, reminders types.ReminderOffsets) error {
 	var userThatSubscribes types.BirthdayUser
 	var subscriptions []types.BirthdayUser
 
@@ -138,7 +412,11 @@ func (db DataBase) SubscribeToUser(userThatSubscibesId, userToSubscribeid int) e
 		return err
 	}
 
-	err = db.DB.Model(&userThatSubscribes).Association(MANY_TO_MANY_FIELD).Append(&userToSubscribe)
+	err = db.DB.Create(&types.UserSubscription{
+		BirthdayUserID:  userThatSubscribes.ID,
+		SubscriptionID:  userToSubscribe.ID,
+		ReminderOffsets: reminderOffsets(reminders),
+	}).Error
 	if err != nil {
 		return err
 	}
@@ -146,6 +424,38 @@ func (db DataBase) SubscribeToUser(userThatSubscibesId, userToSubscribeid int) e
 	return nil
 }
 
+func reminderOffsets(reminders types.ReminderOffsets) types.ReminderOffsets {
+	if len(reminders) == 0 {
+		return types.DefaultReminderOffsets
+	}
+	return reminders.Normalize()
+}
+
+// GetSubscriptionSettings returns the settings of a direct subscription.
+func (db DataBase) GetSubscriptionSettings(userThatSubscibesId, userToSubscribeid int) (types.UserSubscription, error) {
+	var subscription types.UserSubscription
+	err := db.DB.Where("birthday_user_id = ? AND "+THROUGH_MANY_TO_MANY_TABLE_SECOND_COLUMN+" = ?", userThatSubscibesId, userToSubscribeid).First(&subscription).Error
+	if err != nil {
+		return types.UserSubscription{}, err
+	}
+	return subscription, nil
+}
+
+func (db DataBase) UpdateSubscriptionSettings(userThatSubscibesId, userToSubscribeid int, settings types.SubscriptionSettings) (types.UserSubscription, error) {
+	subscription, err := db.GetSubscriptionSettings(userThatSubscibesId, userToSubscribeid)
+	if err != nil {
+		return types.UserSubscription{}, err
+	}
+	subscription.ReminderOffsets = reminderOffsets(settings.ReminderOffsets)
+	err = db.DB.Model(&types.UserSubscription{}).
+		Where("birthday_user_id = ? AND "+THROUGH_MANY_TO_MANY_TABLE_SECOND_COLUMN+" = ?", userThatSubscibesId, userToSubscribeid).
+		Update("reminder_offsets", subscription.ReminderOffsets).Error
+	if err != nil {
+		return types.UserSubscription{}, err
+	}
+	return subscription, nil
+}
+
 func (db DataBase) UnSubscribeFromUser(userThatSubscibesId, userToSubscribeid int) error {
 	var userThatSubscribes types.BirthdayUser
 
@@ -165,30 +475,53 @@ func (db DataBase) UnSubscribeFromUser(userThatSubscibesId, userToSubscribeid in
 		return err
 	}
 
-	return nil
+	// Unsubscribing also withdraws a request that is still pending.
+	return db.DB.Where("subscriber_id = ? AND user_id = ?", userThatSubscibesId, userToSubscribeid).Delete(&types.SubscriptionRequest{}).Error
 }
 
-func (db DataBase) GetBirthdays(userThatSubscibesId int, r *http.Request) ([]types.BirthdayUserResponse, error) {
+func (db DataBase) GetBirthdays(userThatSubscibesId int, r *http.Request) (types.Page[types.BirthdayUserResponse], error) {
 	var userThatSubscribes types.BirthdayUser
-	var subscriptions []types.BirthdayUserResponse
-
 	err := db.DB.First(&userThatSubscribes, userThatSubscibesId).Error
 	if err != nil {
-		return nil, err
+		return types.Page[types.BirthdayUserResponse]{}, err
 	}
 
-	currentTime := time.Now()
-	curentMonth := currentTime.Month()
-	currentDay := currentTime.Day()
+	birthdayToday, args := db.birthdayOn("birthday_users.birthday", dates.Today(userThatSubscribes.Timezone))
+	return db.paginateUsers(db.subscriptionsOf(userThatSubscibesId).Where(birthdayToday, args...), userOrder{}, types.Viewer{ID: userThatSubscibesId}, r)
+}
 
-	err = db.DB.Model(&userThatSubscribes).Scopes(Paginate(r)).Where("EXTRACT(MONTH FROM birthday) = ? AND EXTRACT(DAY FROM birthday) = ?", curentMonth, currentDay).Association(MANY_TO_MANY_FIELD).Find(&subscriptions)
+// GetUpcomingBirthdays returns the subscriptions whose next birthday on or
+// after from is no later than to, ordered by that next birthday.
+func (db DataBase) GetUpcomingBirthdays(userThatSubscibesId int, today, from, to time.Time) ([]types.UpcomingBirthday, error) {
+	subscriptions, err := db.GetAllSubscriptions(userThatSubscibesId)
 	if err != nil {
 		return nil, err
 	}
-	return subscriptions, nil
+
+	upcoming := []types.UpcomingBirthday{}
+	for _, user := range subscriptions {
+		next := db.LeapDayPolicy.NextBirthday(user.Birthday, from)
+		if next.After(dates.Day(to)) {
+			continue
+		}
+		upcoming = append(upcoming, types.UpcomingBirthday{
+			BirthdayUserResponse: user,
+			NextBirthday:         next.Format(time.DateOnly),
+			DaysUntil:            dates.DaysBetween(today, next),
+			TurningAge:           next.Year() - user.Birthday.Year(),
+		})
+	}
+	sort.SliceStable(upcoming, func(i, j int) bool {
+		if upcoming[i].NextBirthday != upcoming[j].NextBirthday {
+			return upcoming[i].NextBirthday < upcoming[j].NextBirthday
+		}
+		return upcoming[i].ID < upcoming[j].ID
+	})
+	return upcoming, nil
 }
 
-func (db DataBase) GetSubscriptions(userThatSubscibesId int, r *http.Request) ([]types.BirthdayUserResponse, error) {
+// GetAllSubscriptions is GetSubscriptions without pagination.
+func (db DataBase) GetAllSubscriptions(userThatSubscibesId int) ([]types.BirthdayUserResponse, error) {
 	var userThatSubscribes types.BirthdayUser
 	var subscriptions []types.BirthdayUserResponse
 
@@ -197,13 +530,67 @@ func (db DataBase) GetSubscriptions(userThatSubscibesId int, r *http.Request) ([
 		return nil, err
 	}
 
-	err = db.DB.Model(&userThatSubscribes).Scopes(Paginate(r)).Association(MANY_TO_MANY_FIELD).Find(&subscriptions)
+	viewer := types.Viewer{ID: userThatSubscibesId}
+	err = db.subscriptionsOf(userThatSubscibesId).Scopes(visibleTo(viewer)).Select("birthday_users.*").
+		Order("birthday_users.id ASC").Find(&subscriptions).Error
 	if err != nil {
 		return nil, err
 	}
+	for i := range subscriptions {
+		subscriptions[i] = viewer.Redact(subscriptions[i])
+	}
 	return subscriptions, nil
 }
 
+func (db DataBase) GetSubscriptions(userThatSubscibesId int, r *http.Request) (types.Page[types.BirthdayUserResponse], error) {
+	var userThatSubscribes types.BirthdayUser
+	err := db.DB.First(&userThatSubscribes, userThatSubscibesId).Error
+	if err != nil {
+		return types.Page[types.BirthdayUserResponse]{}, err
+	}
+
+	return db.paginateUsers(db.subscriptionsOf(userThatSubscibesId), userOrder{}, types.Viewer{ID: userThatSubscibesId}, r)
+}
+
+// visibleTo keeps the users whose visibility setting lets viewer see them.
+func visibleTo(viewer types.Viewer) func(tx *gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		if viewer.Admin {
+			return tx
+		}
+		return tx.Where("(birthday_users.visibility = ? OR birthday_users.id = ? OR (birthday_users.visibility = ? AND EXISTS (SELECT 1 FROM "+SUBSCRIPTIONS_TABLE+" viewer_subscriptions WHERE viewer_subscriptions."+THROUGH_MANY_TO_MANY_TABLE_SECOND_COLUMN+" = birthday_users.id AND viewer_subscriptions.birthday_user_id = ?)))",
+			types.VisibilityEveryone, viewer.ID, types.VisibilitySubscribers, viewer.ID)
+	}
+}
+
+// subscriptionPairs lists every (subscriber_id, birthday_user_id) pair, the
+// direct subscriptions and those coming from group subscriptions. A group
+// doesn't get around privacy settings: it only covers members whose profile
+// is visible to everyone and who don't require approval to be subscribed to.
+// UNION also removes the duplicates of users followed both ways.
+var subscriptionPairs = "SELECT birthday_user_id AS subscriber_id, " + THROUGH_MANY_TO_MANY_TABLE_SECOND_COLUMN + " AS birthday_user_id FROM " + SUBSCRIPTIONS_TABLE +
+	" UNION SELECT group_subscriptions.subscriber_id, group_members.user_id" + groupSubscriptionMembers
+
+var groupSubscriptionMembers = " FROM group_subscriptions" +
+	" JOIN group_members ON group_members.group_id = group_subscriptions.group_id" +
+	" JOIN birthday_users members ON members.id = group_members.user_id" +
+	" WHERE group_members.user_id <> group_subscriptions.subscriber_id" +
+	" AND members.visibility = '" + types.VisibilityEveryone + "' AND NOT members.require_subscription_approval"
+
+// reminderPairs is subscriptionPairs with the reminder offsets of each pair.
+// Group subscriptions remind on the day itself, unless the member is also
+// subscribed to directly.
+var reminderPairs = "SELECT birthday_user_id AS subscriber_id, " + THROUGH_MANY_TO_MANY_TABLE_SECOND_COLUMN + " AS birthday_user_id, reminder_offsets FROM " + SUBSCRIPTIONS_TABLE +
+	" UNION SELECT group_subscriptions.subscriber_id, group_members.user_id, '0'" + groupSubscriptionMembers +
+	" AND NOT EXISTS (SELECT 1 FROM " + SUBSCRIPTIONS_TABLE + " direct WHERE direct.birthday_user_id = group_subscriptions.subscriber_id AND direct." + THROUGH_MANY_TO_MANY_TABLE_SECOND_COLUMN + " = group_members.user_id)"
+
+// subscriptionsOf selects the users whose birthdays the given user is
+// subscribed to, directly or through a group.
+func (db DataBase) subscriptionsOf(userThatSubscibesId int) *gorm.DB {
+	return db.DB.Model(&types.BirthdayUser{}).
+		Where("birthday_users.id IN (SELECT birthday_user_id FROM ("+subscriptionPairs+") subscription_pairs WHERE subscriber_id = ?)", userThatSubscibesId)
+}
+
 func (db DataBase) UpdateUser(id int, newUser types.BirthdayUserRequest) (types.BirthdayUserResponse, error) {
 	var oldUser types.BirthdayUser
 	err := db.DB.First(&oldUser, id).Error
@@ -212,25 +599,31 @@ func (db DataBase) UpdateUser(id int, newUser types.BirthdayUserRequest) (types.
 	}
 	oldUser.FirstName = newUser.FirstName
 	oldUser.LastName = newUser.LastName
+	emailChanged := newUser.Email != oldUser.Email
+	if emailChanged {
+		oldUser.EmailVerified = false
+	}
 	oldUser.Email = newUser.Email
 	oldUser.Birthday = newUser.Birthday
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newUser.Password), 14)
+	oldUser.Timezone = newUser.Timezone
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newUser.Password), PasswordHashCost)
 	if err != nil {
 		return types.BirthdayUserResponse{}, err
 	}
 	oldUser.Password = string(hashedPassword)
-	err = db.DB.Save(&oldUser).Error
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Save(&oldUser).Error
+		if err != nil || !emailChanged {
+			return err
+		}
+		return deletePasswordResetTokens(tx, id)
+	})
 	if err != nil {
 		return types.BirthdayUserResponse{}, err
 	}
 	return types.BirthdayUserResponse{
-		ID: oldUser.ID,
-		BirthdayUserBase: types.BirthdayUserBase{
-			FirstName: oldUser.FirstName,
-			LastName:  oldUser.LastName,
-			Email:     oldUser.Email,
-			Birthday:  oldUser.Birthday,
-		},
+		ID:               oldUser.ID,
+		BirthdayUserBase: oldUser.BirthdayUserBase,
 	}, nil
 }
 
@@ -243,23 +636,422 @@ func (db DataBase) PatchUser(id int, newUser types.BirthdayUserRequest) (types.B
 	pass := newUser.Password
 	var hashedPassword []byte
 	if pass != "" {
-		hashedPassword, err = bcrypt.GenerateFromPassword([]byte(newUser.Password), 14)
+		hashedPassword, err = bcrypt.GenerateFromPassword([]byte(newUser.Password), PasswordHashCost)
 		if err != nil {
 			return types.BirthdayUserResponse{}, err
 		}
 	}
 	newUser.Password = string(hashedPassword)
-	err = db.DB.Model(&oldUser).Updates(&newUser).Error
+	emailChanged := newUser.Email != "" && newUser.Email != oldUser.Email
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&oldUser).Updates(&newUser).Error
+		if err != nil || !emailChanged {
+			return err
+		}
+		err = tx.Model(&oldUser).Update("email_verified", false).Error
+		if err != nil {
+			return err
+		}
+		return deletePasswordResetTokens(tx, id)
+	})
 	if err != nil {
 		return types.BirthdayUserResponse{}, err
 	}
 	return types.BirthdayUserResponse{
-		ID: oldUser.ID,
-		BirthdayUserBase: types.BirthdayUserBase{
-			FirstName: oldUser.FirstName,
-			LastName:  oldUser.LastName,
-			Email:     oldUser.Email,
-			Birthday:  oldUser.Birthday,
-		},
+		ID:               oldUser.ID,
+		BirthdayUserBase: oldUser.BirthdayUserBase,
 	}, nil
 }
+
+// GetTimezones returns the distinct time zones of users with at least one
+// subscription.
+func (db DataBase) GetTimezones() ([]string, error) {
+	var timezones []string
+	err := db.DB.Model(&types.BirthdayUser{}).
+		Where("id IN (SELECT subscriber_id FROM ("+subscriptionPairs+") subscription_pairs)").
+		Distinct().Pluck("timezone", &timezones).Error
+	if err != nil {
+		return nil, err
+	}
+	return timezones, nil
+}
+
+// GetReminderOffsets returns every reminder offset in use, which
+// GetPendingNotifications needs. It reads the whole subscriptions table, so
+// the scheduler loads them once per run rather than once per time zone.
+func (db DataBase) GetReminderOffsets() (types.ReminderOffsets, error) {
+	var lists []types.ReminderOffsets
+	err := db.DB.Model(&types.UserSubscription{}).Distinct().Pluck("reminder_offsets", &lists).Error
+	if err != nil {
+		return nil, err
+	}
+	// Group subscriptions always remind on the day itself.
+	offsets := types.ReminderOffsets{0}
+	for _, list := range lists {
+		offsets = append(offsets, list...)
+	}
+	return offsets.Normalize(), nil
+}
+
+// GetPendingNotifications returns the birthdays the subscribers living in the
+// given time zone, for whom it is date now, are to be reminded of today and
+// weren't yet. offsets are those from GetReminderOffsets.
+func (db DataBase) GetPendingNotifications(timezone string, date time.Time, offsets types.ReminderOffsets) ([]types.PendingNotification, error) {
+	reminderDue, args := db.reminderDue(date, offsets)
+	var pairs []struct {
+		SubscriberID   int
+		BirthdayUserID int
+	}
+	err := db.DB.Table("("+reminderPairs+") subscription_pairs").
+		Select("subscription_pairs.subscriber_id, subscription_pairs.birthday_user_id").
+		Joins("JOIN birthday_users ON birthday_users.id = subscription_pairs.birthday_user_id").
+		Joins("JOIN birthday_users subscribers ON subscribers.id = subscription_pairs.subscriber_id").
+		Where("subscribers.timezone = ? AND subscribers.delivery = ?", timezone, types.DeliveryInstant).
+		Scopes(db.verifiedEmail("subscribers")).
+		Where("birthday_users.visibility <> ?", types.VisibilityNobody).
+		Where(reminderDue, args...).
+		Where("NOT EXISTS (SELECT 1 FROM notifications WHERE notifications.subscriber_id = subscription_pairs.subscriber_id AND notifications.birthday_user_id = subscription_pairs.birthday_user_id AND notifications.date = ?)", date.Format(time.DateOnly)).
+		Order("subscription_pairs.subscriber_id ASC, subscription_pairs.birthday_user_id ASC").
+		Scan(&pairs).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]int, 0, len(pairs)*2)
+	for _, pair := range pairs {
+		ids = append(ids, pair.SubscriberID, pair.BirthdayUserID)
+	}
+	var users []types.BirthdayUserResponse
+	err = db.DB.Model(&types.BirthdayUser{}).Where("id IN ?", ids).Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	usersById := make(map[int]types.BirthdayUserResponse, len(users))
+	for _, user := range users {
+		usersById[user.ID] = user
+	}
+
+	var pending []types.PendingNotification
+	for _, pair := range pairs {
+		if len(pending) == 0 || pending[len(pending)-1].Subscriber.ID != pair.SubscriberID {
+			pending = append(pending, types.PendingNotification{Subscriber: usersById[pair.SubscriberID]})
+		}
+		last := &pending[len(pending)-1]
+		viewer := types.Viewer{ID: pair.SubscriberID}
+		user := viewer.Redact(usersById[pair.BirthdayUserID])
+		next := db.LeapDayPolicy.NextBirthday(user.Birthday, date)
+		last.Birthdays = append(last.Birthdays, types.UpcomingBirthday{
+			BirthdayUserResponse: user,
+			NextBirthday:         next.Format(time.DateOnly),
+			DaysUntil:            dates.DaysBetween(date, next),
+			TurningAge:           next.Year() - user.Birthday.Year(),
+		})
+	}
+	return pending, nil
+}
+
+// reminderDue returns a condition on reminderPairs joined with the birthday
+// users matching the birthdays that are one of the reminder offsets of the
+// pair away from date.
+func (db DataBase) reminderDue(date time.Time, offsets types.ReminderOffsets) (string, []any) {
+	var conditions []string
+	var args []any
+	for _, offset := range offsets {
+		birthdayOn, birthdayArgs := db.birthdayOn("birthday_users.birthday", date.AddDate(0, 0, offset))
+		conditions = append(conditions, "("+birthdayOn+" AND ',' || subscription_pairs.reminder_offsets || ',' LIKE ?)")
+		args = append(args, birthdayArgs...)
+		args = append(args, "%,"+strconv.Itoa(offset)+",%")
+	}
+	return "(" + strings.Join(conditions, " OR ") + ")", args
+}
+
+func (db DataBase) RecordNotifications(subscriberId int, birthdayUserIds []int, date time.Time) error {
+	notifications := make([]types.Notification, 0, len(birthdayUserIds))
+	for _, birthdayUserId := range birthdayUserIds {
+		notifications = append(notifications, types.Notification{
+			SubscriberID:   subscriberId,
+			BirthdayUserID: birthdayUserId,
+			Date:           date.Format(time.DateOnly),
+			SentAt:         time.Now(),
+		})
+	}
+	return db.DB.Create(&notifications).Error
+}
+
+// verifiedEmail leaves out the users in table who haven't verified their
+// email, when that is required.
+func (db DataBase) verifiedEmail(table string) func(tx *gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		if !db.RequireVerifiedEmail {
+			return tx
+		}
+		return tx.Where(table + ".email_verified")
+	}
+}
+
+func (db DataBase) GetUserRole(id int) (string, error) {
+	var user types.BirthdayUser
+	err := db.DB.Select("role").First(&user, id).Error
+	if err != nil {
+		return "", err
+	}
+	return user.Role, nil
+}
+
+func (db DataBase) SetUserRole(id int, role string) error {
+	return db.DB.Model(&types.BirthdayUser{}).Where("id = ?", id).Update("role", role).Error
+}
+
+func (db DataBase) GetPrivacySettings(id int) (types.PrivacySettings, error) {
+	var user types.BirthdayUser
+	err := db.DB.Select("hide_birth_year", "hide_email", "visibility", "require_subscription_approval").First(&user, id).Error
+	if err != nil {
+		return types.PrivacySettings{}, err
+	}
+	return user.PrivacySettings, nil
+}
+
+func (db DataBase) UpdatePrivacySettings(id int, settings types.PrivacySettings) error {
+	// A map, because Updates skips the false values of a struct.
+	return db.DB.Model(&types.BirthdayUser{}).Where("id = ?", id).Updates(map[string]any{
+		"hide_birth_year":               settings.HideBirthYear,
+		"hide_email":                    settings.HideEmail,
+		"visibility":                    settings.Visibility,
+		"require_subscription_approval": settings.RequireSubscriptionApproval,
+	}).Error
+}
+
+// DeleteUser removes the user together with everything that references them.
+func (db DataBase) DeleteUser(id int) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		var user types.BirthdayUser
+		err := tx.Select("email").First(&user, id).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Delete(&types.BirthdayUser{}, id).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Exec("DELETE FROM "+SUBSCRIPTIONS_TABLE+" WHERE birthday_user_id = ? OR "+THROUGH_MANY_TO_MANY_TABLE_SECOND_COLUMN+" = ?", id, id).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("subscriber_id = ? OR user_id = ?", id, id).Delete(&types.SubscriptionRequest{}).Error
+		if err != nil {
+			return err
+		}
+		err = handOverGroups(tx, id)
+		if err != nil {
+			return err
+		}
+		err = tx.Where("user_id = ?", id).Delete(&types.GroupMember{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("subscriber_id = ?", id).Delete(&types.GroupSubscription{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("subscriber_id = ? OR birthday_user_id = ?", id, id).Delete(&types.Notification{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("subscriber_id = ?", id).Delete(&types.DigestDelivery{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("birthday_user_id = ?", id).Delete(&types.WebhookDelivery{}).Error
+		if err != nil {
+			return err
+		}
+
+		sessions := tx.Model(&types.Session{}).Select("id").Where("user_id = ?", id)
+		err = tx.Where("session_id IN (?)", sessions).Delete(&types.RefreshToken{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("user_id = ?", id).Delete(&types.Session{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("user_id = ?", id).Delete(&types.PasswordResetToken{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("user_id = ?", id).Delete(&types.EmailVerificationToken{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("user_id = ?", id).Delete(&types.APIKey{}).Error
+		if err != nil {
+			return err
+		}
+		err = clearLoginFailures(tx, user.Email)
+		if err != nil {
+			return err
+		}
+
+		webhooks := tx.Model(&types.Webhook{}).Select("id").Where("user_id = ?", id)
+		err = tx.Where("webhook_id IN (?)", webhooks).Delete(&types.WebhookDelivery{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", id).Delete(&types.Webhook{}).Error
+	})
+}
+
+func (db DataBase) SetCalendarToken(userId int, tokenHash string) error {
+	return db.DB.Model(&types.BirthdayUser{}).Where("id = ?", userId).Update("calendar_token_hash", tokenHash).Error
+}
+
+func (db DataBase) GetUserIdByCalendarToken(tokenHash string) (int, error) {
+	var user types.BirthdayUser
+	err := db.DB.Select("id").Where("calendar_token_hash = ?", tokenHash).First(&user).Error
+	if err != nil {
+		return 0, err
+	}
+	return user.ID, nil
+}
+
+// streamBatchSize is how many users StreamUsers and StreamSubscriptions read
+// at a time.
+const streamBatchSize int = 500
+
+// StreamUsers calls fn for every user without loading the whole table into
+// memory. Users are read in batches rather than from an open cursor, so that
+// a slow client doesn't hold on to a database connection, SQLite has only
+// one, for the whole export.
+func (db DataBase) StreamUsers(viewer types.Viewer, fn func(types.BirthdayUserResponse) error) error {
+	return db.streamUsers(func() *gorm.DB {
+		return db.DB.Model(&types.BirthdayUser{}).Scopes(visibleTo(viewer))
+	}, viewer, fn)
+}
+
+// StreamSubscriptions is StreamUsers for the subscriptions of a user.
+func (db DataBase) StreamSubscriptions(userThatSubscibesId int, fn func(types.BirthdayUserResponse) error) error {
+	var userThatSubscribes types.BirthdayUser
+	err := db.DB.First(&userThatSubscribes, userThatSubscibesId).Error
+	if err != nil {
+		return err
+	}
+
+	viewer := types.Viewer{ID: userThatSubscibesId}
+	return db.streamUsers(func() *gorm.DB {
+		return db.subscriptionsOf(userThatSubscibesId).Scopes(visibleTo(viewer)).Select("birthday_users.*")
+	}, viewer, fn)
+}
+
+// streamUsers pages through the users query returns by id, streamBatchSize
+// at a time, each batch being a query of its own that starts after the last
+// id of the previous one. Unlike a single cursor over the whole result, this
+// holds no connection or read transaction while the client is slow, but
+// users created, changed or deleted during the export may or may not show up
+// in it.
+func (db DataBase) streamUsers(query func() *gorm.DB, viewer types.Viewer, fn func(types.BirthdayUserResponse) error) error {
+	lastId := 0
+	for {
+		var users []types.BirthdayUserResponse
+		err := query().Where("birthday_users.id > ?", lastId).Order("birthday_users.id ASC").Limit(streamBatchSize).Find(&users).Error
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			err = fn(viewer.Redact(user))
+			if err != nil {
+				return err
+			}
+		}
+		if len(users) < streamBatchSize {
+			return nil
+		}
+		lastId = users[len(users)-1].ID
+	}
+}
+
+func (db DataBase) GetUserDataExport(id int) (types.UserDataExport, error) {
+	var user types.BirthdayUser
+	err := db.DB.First(&user, id).Error
+	if err != nil {
+		return types.UserDataExport{}, err
+	}
+
+	export := types.UserDataExport{
+		ExportedAt:          time.Now(),
+		Profile:             types.BirthdayUserResponse{ID: user.ID, BirthdayUserBase: user.BirthdayUserBase},
+		Role:                user.Role,
+		EmailVerified:       user.EmailVerified,
+		Privacy:             user.PrivacySettings,
+		DeliveryPreferences: user.DeliveryPreferences,
+		CalendarFeedEnabled: user.CalendarTokenHash != "",
+	}
+	export.Subscriptions, err = db.GetAllSubscriptions(id)
+	if err != nil {
+		return types.UserDataExport{}, err
+	}
+	err = db.DB.Table(SUBSCRIPTIONS_TABLE).Where(THROUGH_MANY_TO_MANY_TABLE_SECOND_COLUMN+" = ?", id).Order("birthday_user_id ASC").Pluck("birthday_user_id", &export.SubscriberIDs).Error
+	if err != nil {
+		return types.UserDataExport{}, err
+	}
+	err = db.DB.Where("subscriber_id = ? OR user_id = ?", id, id).Order("id ASC").Find(&export.SubscriptionRequests).Error
+	if err != nil {
+		return types.UserDataExport{}, err
+	}
+	err = db.DB.Where("user_id = ?", id).Order("group_id ASC").Find(&export.GroupMemberships).Error
+	if err != nil {
+		return types.UserDataExport{}, err
+	}
+	err = db.DB.Where("subscriber_id = ?", id).Order("group_id ASC").Find(&export.GroupSubscriptions).Error
+	if err != nil {
+		return types.UserDataExport{}, err
+	}
+	err = db.DB.Where("subscriber_id = ?", id).Order("id ASC").Find(&export.Notifications).Error
+	if err != nil {
+		return types.UserDataExport{}, err
+	}
+	err = db.DB.Where("birthday_user_id = ?", id).Order(THROUGH_MANY_TO_MANY_TABLE_SECOND_COLUMN + " ASC").Find(&export.SubscriptionSettings).Error
+	if err != nil {
+		return types.UserDataExport{}, err
+	}
+	err = db.DB.Where("subscriber_id = ?", id).Order("id ASC").Find(&export.DigestDeliveries).Error
+	if err != nil {
+		return types.UserDataExport{}, err
+	}
+	export.Webhooks, err = db.GetWebhooks(id)
+	if err != nil {
+		return types.UserDataExport{}, err
+	}
+	for i := range export.Webhooks {
+		export.Webhooks[i].Secret = ""
+	}
+	webhooks := db.DB.Model(&types.Webhook{}).Select("id").Where("user_id = ?", id)
+	err = db.DB.Where("webhook_id IN (?)", webhooks).Order("id ASC").Find(&export.WebhookDeliveries).Error
+	if err != nil {
+		return types.UserDataExport{}, err
+	}
+	err = db.DB.Where("user_id = ?", id).Order("id ASC").Find(&export.Sessions).Error
+	if err != nil {
+		return types.UserDataExport{}, err
+	}
+	err = db.DB.Where("user_id = ?", id).Order("id ASC").Find(&export.PasswordResetTokens).Error
+	if err != nil {
+		return types.UserDataExport{}, err
+	}
+	err = db.DB.Where("user_id = ?", id).Order("id ASC").Find(&export.EmailVerificationTokens).Error
+	if err != nil {
+		return types.UserDataExport{}, err
+	}
+	export.APIKeys, err = db.GetAPIKeys(id)
+	if err != nil {
+		return types.UserDataExport{}, err
+	}
+	err = db.DB.Where("kind = ? AND subject = ?", types.ThrottleAccount, accountSubject(user.Email)).Find(&export.LoginThrottles).Error
+	if err != nil {
+		return types.UserDataExport{}, err
+	}
+	return export, nil
+}
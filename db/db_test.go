@@ -0,0 +1,177 @@
+package db
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"birthday/dates"
+	"birthday/types"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestDataBase(t *testing.T) DataBase {
+	t.Helper()
+	database, err := NewMemoryDataBase(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	database.DB = database.DB.Session(&gorm.Session{Logger: logger.Discard})
+	sqlDB, err := database.DB.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	err = database.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return database
+}
+
+func createTestUser(t *testing.T, database DataBase, name string) int {
+	t.Helper()
+	user := types.BirthdayUser{BirthdayUserRequest: types.BirthdayUserRequest{BirthdayUserBase: types.BirthdayUserBase{
+		FirstName: name, LastName: "Test", Email: name + "@example.com", Birthday: time.Date(1990, time.May, 16, 0, 0, 0, 0, time.UTC),
+	}}}
+	err := database.DB.Create(&user).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user.ID
+}
+
+// TestBirthdayOnLeapDay checks the SQL that today's birthdays and the
+// notifications are matched with against the leap day policy.
+func TestBirthdayOnLeapDay(t *testing.T) {
+	database := newTestDataBase(t)
+	for _, user := range []struct {
+		name string
+		born time.Time
+	}{
+		{"leap", time.Date(2000, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"feb28", time.Date(1999, time.February, 28, 0, 0, 0, 0, time.UTC)},
+		{"mar1", time.Date(1999, time.March, 1, 0, 0, 0, 0, time.UTC)},
+	} {
+		err := database.DB.Create(&types.BirthdayUser{BirthdayUserRequest: types.BirthdayUserRequest{BirthdayUserBase: types.BirthdayUserBase{
+			FirstName: user.name, LastName: "Test", Email: user.name + "@example.com", Birthday: user.born,
+		}}}).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		policy dates.LeapDayPolicy
+		day    time.Time
+		want   []string
+	}{
+		{dates.LeapDayFeb28, time.Date(2023, time.February, 28, 0, 0, 0, 0, time.UTC), []string{"feb28", "leap"}},
+		{dates.LeapDayFeb28, time.Date(2023, time.March, 1, 0, 0, 0, 0, time.UTC), []string{"mar1"}},
+		{dates.LeapDayFeb28, time.Date(2024, time.February, 28, 0, 0, 0, 0, time.UTC), []string{"feb28"}},
+		{dates.LeapDayFeb28, time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC), []string{"leap"}},
+		{dates.LeapDayMar1, time.Date(2023, time.February, 28, 0, 0, 0, 0, time.UTC), []string{"feb28"}},
+		{dates.LeapDayMar1, time.Date(2023, time.March, 1, 0, 0, 0, 0, time.UTC), []string{"leap", "mar1"}},
+		{dates.LeapDayMar1, time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC), []string{"mar1"}},
+		{dates.LeapDayMar1, time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC), []string{"leap"}},
+		{dates.LeapDayLeapYearsOnly, time.Date(2023, time.February, 28, 0, 0, 0, 0, time.UTC), []string{"feb28"}},
+		{dates.LeapDayLeapYearsOnly, time.Date(2023, time.March, 1, 0, 0, 0, 0, time.UTC), []string{"mar1"}},
+		{dates.LeapDayLeapYearsOnly, time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC), []string{"leap"}},
+		{dates.LeapDayLeapYearsOnly, time.Date(2100, time.February, 28, 0, 0, 0, 0, time.UTC), []string{"feb28"}},
+	}
+	for _, tt := range tests {
+		database.LeapDayPolicy = tt.policy
+		condition, args := database.birthdayOn("birthday", tt.day)
+		var got []string
+		err := database.DB.Model(&types.BirthdayUser{}).Where(condition, args...).Order("first_name").Pluck("first_name", &got).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: on %s got %v, want %v", tt.policy, tt.day.Format(time.DateOnly), got, tt.want)
+		}
+	}
+}
+
+func TestDeleteUserHandsOverGroups(t *testing.T) {
+	database := newTestDataBase(t)
+	ann, bob, carol := createTestUser(t, database, "ann"), createTestUser(t, database, "bob"), createTestUser(t, database, "carol")
+	shared, err := database.CreateGroup(ann, types.Group{Name: "shared"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, member := range []int{bob, carol} {
+		err = database.SetGroupMember(shared.ID, types.GroupMemberRequest{UserID: member})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	coOwned, err := database.CreateGroup(ann, types.Group{Name: "co-owned"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = database.SetGroupMember(coOwned.ID, types.GroupMemberRequest{UserID: carol, Owner: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	alone, err := database.CreateGroup(ann, types.Group{Name: "alone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = database.DeleteUser(ann)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		groupId, userId int
+		owner           bool
+	}{{shared.ID, bob, true}, {shared.ID, carol, false}, {coOwned.ID, carol, true}} {
+		owner, err := database.IsGroupOwner(tt.groupId, tt.userId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if owner != tt.owner {
+			t.Errorf("user %d owns group %d: %t, want %t", tt.userId, tt.groupId, owner, tt.owner)
+		}
+	}
+	var groups int64
+	err = database.DB.Model(&types.Group{}).Where("id = ?", alone.ID).Count(&groups).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if groups != 0 {
+		t.Error("kept the group without members")
+	}
+}
+
+func TestDeleteUserForgetsLoginFailures(t *testing.T) {
+	database := newTestDataBase(t)
+	ann := createTestUser(t, database, "ann")
+	err := database.RecordLoginFailure(" Ann@Example.com", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	export, err := database.GetUserDataExport(ann)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(export.LoginThrottles) != 1 || export.LoginThrottles[0].Failures != 1 {
+		t.Errorf("exported login throttles %+v, want the failed login", export.LoginThrottles)
+	}
+
+	err = database.DeleteUser(ann)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var throttles []types.LoginThrottle
+	err = database.DB.Find(&throttles).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(throttles) != 1 || throttles[0].Kind != types.ThrottleIP {
+		t.Errorf("left login throttles %+v, want only the IP address's", throttles)
+	}
+}
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"sort"
+	"time"
+
+	"birthday/dates"
+	"birthday/types"
+)
+
+func (db DataBase) GetDeliveryPreferences(id int) (types.DeliveryPreferences, error) {
+	var user types.BirthdayUser
+	err := db.DB.Select("delivery", "digest_hour").First(&user, id).Error
+	if err != nil {
+		return types.DeliveryPreferences{}, err
+	}
+	return user.DeliveryPreferences, nil
+}
+
+func (db DataBase) UpdateDeliveryPreferences(id int, preferences types.DeliveryPreferences) error {
+	// A map, because Updates skips the zero values of a struct.
+	return db.DB.Model(&types.BirthdayUser{}).Where("id = ?", id).Updates(map[string]any{
+		"delivery":    preferences.Delivery,
+		"digest_hour": preferences.DigestHour,
+	}).Error
+}
+
+// GetDigestSubscribers returns the subscribers living in the given time zone
+// who get digests of the given kind, whose digest hour has come and who
+// haven't received the digest starting on date yet.
+func (db DataBase) GetDigestSubscribers(timezone, delivery string, hour int, date time.Time) ([]types.BirthdayUserResponse, error) {
+	var subscribers []types.BirthdayUserResponse
+	err := db.DB.Model(&types.BirthdayUser{}).
+		Where("timezone = ? AND delivery = ? AND digest_hour <= ?", timezone, delivery, hour).
+		Scopes(db.verifiedEmail("birthday_users")).
+		Where("id IN (SELECT subscriber_id FROM ("+subscriptionPairs+") subscription_pairs)").
+		Where("NOT EXISTS (SELECT 1 FROM digest_deliveries WHERE digest_deliveries.subscriber_id = birthday_users.id AND digest_deliveries.delivery = ? AND digest_deliveries.date = ?)", delivery, date.Format(time.DateOnly)).
+		Order("id ASC").Find(&subscribers).Error
+	if err != nil {
+		return nil, err
+	}
+	return subscribers, nil
+}
+
+// GetDigestBirthdays returns the birthdays the subscriber is to be reminded
+// of from from to to, ordered by date: those one of the reminder offsets of
+// the subscription puts a reminder within the period for. With the default
+// offsets these are the birthdays of the period.
+func (db DataBase) GetDigestBirthdays(subscriberId int, from, to time.Time) ([]types.UpcomingBirthday, error) {
+	subscriptions, err := db.GetAllSubscriptions(subscriberId)
+	if err != nil {
+		return nil, err
+	}
+	var pairs []struct {
+		BirthdayUserID  int
+		ReminderOffsets types.ReminderOffsets
+	}
+	err = db.DB.Table("("+reminderPairs+") subscription_pairs").
+		Select("birthday_user_id, reminder_offsets").
+		Where("subscriber_id = ?", subscriberId).
+		Scan(&pairs).Error
+	if err != nil {
+		return nil, err
+	}
+	offsetsById := make(map[int]types.ReminderOffsets, len(pairs))
+	for _, pair := range pairs {
+		offsetsById[pair.BirthdayUserID] = pair.ReminderOffsets
+	}
+
+	birthdays := []types.UpcomingBirthday{}
+	for _, user := range subscriptions {
+		for _, offset := range offsetsById[user.ID].Normalize() {
+			next := db.LeapDayPolicy.NextBirthday(user.Birthday, from.AddDate(0, 0, offset))
+			if next.After(dates.Day(to).AddDate(0, 0, offset)) {
+				continue
+			}
+			birthdays = append(birthdays, types.UpcomingBirthday{
+				BirthdayUserResponse: user,
+				NextBirthday:         next.Format(time.DateOnly),
+				DaysUntil:            dates.DaysBetween(from, next),
+				TurningAge:           next.Year() - user.Birthday.Year(),
+			})
+			break
+		}
+	}
+	sort.SliceStable(birthdays, func(i, j int) bool {
+		if birthdays[i].NextBirthday != birthdays[j].NextBirthday {
+			return birthdays[i].NextBirthday < birthdays[j].NextBirthday
+		}
+		return birthdays[i].ID < birthdays[j].ID
+	})
+	return birthdays, nil
+}
+
+func (db DataBase) RecordDigest(subscriberId int, delivery string, date time.Time) error {
+	return db.DB.Create(&types.DigestDelivery{
+		SubscriberID: subscriberId,
+		Delivery:     delivery,
+		Date:         date.Format(time.DateOnly),
+		SentAt:       time.Now(),
+	}).Error
+}
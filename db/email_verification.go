@@ -0,0 +1,79 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"birthday/types"
+
+	"gorm.io/gorm"
+)
+
+var ErrInvalidVerificationToken = errors.New("invalid or expired email verification token")
+
+func (db DataBase) IsEmailVerified(id int) (bool, error) {
+	var user types.BirthdayUser
+	err := db.DB.Select("email_verified").First(&user, id).Error
+	if err != nil {
+		return false, err
+	}
+	return user.EmailVerified, nil
+}
+
+// CreateEmailVerificationToken stores a new token confirming that the user
+// owns email, replacing the tokens sent earlier.
+func (db DataBase) CreateEmailVerificationToken(userId int, email, tokenHash string, expiresAt time.Time) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("user_id = ?", userId).Delete(&types.EmailVerificationToken{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&types.EmailVerificationToken{UserID: userId, Email: email, TokenHash: tokenHash, ExpiresAt: expiresAt}).Error
+	})
+}
+
+// VerifyEmail uses up the token and marks the email of its user as verified.
+// A token sent to an address the user has since changed verifies nothing,
+// but is used up all the same.
+func (db DataBase) VerifyEmail(tokenHash string) error {
+	var stale bool
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var verificationToken types.EmailVerificationToken
+		err := tx.Where("token_hash = ?", tokenHash).First(&verificationToken).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidVerificationToken
+			}
+			return err
+		}
+		if time.Now().After(verificationToken.ExpiresAt) {
+			return ErrInvalidVerificationToken
+		}
+		var users int64
+		err = tx.Model(&types.BirthdayUser{}).
+			Where("id = ? AND email = ?", verificationToken.UserID, verificationToken.Email).
+			Count(&users).Error
+		if err != nil {
+			return err
+		}
+
+		// Returning an error would roll the deletion back, so a stale token
+		// is reported once the transaction is committed.
+		result := tx.Delete(&verificationToken)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidVerificationToken
+		}
+		if users == 0 {
+			stale = true
+			return nil
+		}
+		return tx.Model(&types.BirthdayUser{}).Where("id = ?", verificationToken.UserID).Update("email_verified", true).Error
+	})
+	if err == nil && stale {
+		return ErrInvalidVerificationToken
+	}
+	return err
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"birthday/types"
+)
+
+func TestVerifyEmailUsesUpTokens(t *testing.T) {
+	database := newTestDataBase(t)
+	ann := createTestUser(t, database, "ann")
+	expiresAt := time.Now().Add(time.Hour)
+
+	err := database.CreateEmailVerificationToken(ann, "ann@example.com", "changed", expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = database.DB.Model(&types.BirthdayUser{}).Where("id = ?", ann).Update("email", "ann@example.org").Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = database.VerifyEmail("changed")
+	if !errors.Is(err, ErrInvalidVerificationToken) {
+		t.Fatalf("verified the old address: %v", err)
+	}
+	var tokens int64
+	err = database.DB.Model(&types.EmailVerificationToken{}).Count(&tokens).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens != 0 {
+		t.Error("kept the token of the old address")
+	}
+
+	err = database.CreateEmailVerificationToken(ann, "ann@example.org", "current", expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = database.VerifyEmail("current")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = database.VerifyEmail("current")
+	if !errors.Is(err, ErrInvalidVerificationToken) {
+		t.Errorf("used a token twice: %v", err)
+	}
+	verified, err := database.IsEmailVerified(ann)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verified {
+		t.Error("email not verified")
+	}
+}
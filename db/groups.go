@@ -0,0 +1,220 @@
+package db
+
+import (
+	"errors"
+
+	"birthday/types"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrLastGroupOwner = errors.New("a group must keep at least one owner")
+
+func (db DataBase) GetGroups() ([]types.Group, error) {
+	groups := []types.Group{}
+	err := db.DB.Order("id ASC").Find(&groups).Error
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// CreateGroup creates a group owned by the user creating it.
+func (db DataBase) CreateGroup(ownerId int, group types.Group) (types.Group, error) {
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(&group).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&types.GroupMember{GroupID: group.ID, UserID: ownerId, Owner: true}).Error
+	})
+	if err != nil {
+		return types.Group{}, err
+	}
+	return group, nil
+}
+
+// GetGroup returns the group with the members the viewer is allowed to see.
+func (db DataBase) GetGroup(viewer types.Viewer, id int) (types.GroupDetails, error) {
+	var details types.GroupDetails
+	err := db.DB.First(&details.Group, id).Error
+	if err != nil {
+		return types.GroupDetails{}, err
+	}
+
+	var members []types.GroupMember
+	err = db.DB.Where("group_id = ?", id).Find(&members).Error
+	if err != nil {
+		return types.GroupDetails{}, err
+	}
+	details.MemberCount = len(members)
+	owners := make(map[int]bool, len(members))
+	for _, member := range members {
+		owners[member.UserID] = member.Owner
+	}
+
+	var users []types.BirthdayUserResponse
+	err = db.DB.Model(&types.BirthdayUser{}).Scopes(visibleTo(viewer)).
+		Where("id IN (?)", db.DB.Model(&types.GroupMember{}).Select("user_id").Where("group_id = ?", id)).
+		Order("id ASC").Find(&users).Error
+	if err != nil {
+		return types.GroupDetails{}, err
+	}
+	details.Members = make([]types.GroupMemberResponse, 0, len(users))
+	for _, user := range users {
+		details.Members = append(details.Members, types.GroupMemberResponse{User: viewer.Redact(user), Owner: owners[user.ID]})
+	}
+	return details, nil
+}
+
+func (db DataBase) DeleteGroup(id int) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		return deleteGroup(tx, id)
+	})
+}
+
+func deleteGroup(tx *gorm.DB, id int) error {
+	result := tx.Delete(&types.Group{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	err := tx.Where("group_id = ?", id).Delete(&types.GroupMember{}).Error
+	if err != nil {
+		return err
+	}
+	return tx.Where("group_id = ?", id).Delete(&types.GroupSubscription{}).Error
+}
+
+// handOverGroups keeps the groups the user is the only owner of from being
+// left without owners when the user is deleted: the member who joined first
+// becomes the owner, and groups without other members are deleted.
+func handOverGroups(tx *gorm.DB, userId int) error {
+	var groupIds []int
+	err := tx.Model(&types.GroupMember{}).Where("user_id = ? AND owner", userId).Pluck("group_id", &groupIds).Error
+	if err != nil {
+		return err
+	}
+	for _, groupId := range groupIds {
+		var owners int64
+		err = tx.Model(&types.GroupMember{}).Where("group_id = ? AND owner AND user_id <> ?", groupId, userId).Count(&owners).Error
+		if err != nil {
+			return err
+		}
+		if owners > 0 {
+			continue
+		}
+
+		var heirs []types.GroupMember
+		err = tx.Where("group_id = ? AND user_id <> ?", groupId, userId).Order("created_at ASC, user_id ASC").Limit(1).Find(&heirs).Error
+		if err != nil {
+			return err
+		}
+		if len(heirs) == 0 {
+			err = deleteGroup(tx, groupId)
+		} else {
+			err = tx.Model(&types.GroupMember{}).Where("group_id = ? AND user_id = ?", groupId, heirs[0].UserID).Update("owner", true).Error
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db DataBase) IsGroupOwner(groupId, userId int) (bool, error) {
+	var count int64
+	err := db.DB.Model(&types.GroupMember{}).Where("group_id = ? AND user_id = ? AND owner", groupId, userId).Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// SetGroupMember adds a user to the group or changes whether an existing
+// member is an owner.
+func (db DataBase) SetGroupMember(groupId int, member types.GroupMemberRequest) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.First(&types.Group{}, groupId).Error
+		if err != nil {
+			return err
+		}
+		err = tx.First(&types.BirthdayUser{}, member.UserID).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "group_id"}, {Name: "user_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"owner"}),
+		}).Create(&types.GroupMember{GroupID: groupId, UserID: member.UserID, Owner: member.Owner}).Error
+		if err != nil {
+			return err
+		}
+		return db.checkGroupOwners(tx, groupId)
+	})
+}
+
+func (db DataBase) RemoveGroupMember(groupId, userId int) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("group_id = ? AND user_id = ?", groupId, userId).Delete(&types.GroupMember{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return db.checkGroupOwners(tx, groupId)
+	})
+}
+
+// checkGroupOwners fails, rolling back the transaction, when a change left
+// the group without owners.
+func (db DataBase) checkGroupOwners(tx *gorm.DB, groupId int) error {
+	var owners int64
+	err := tx.Model(&types.GroupMember{}).Where("group_id = ? AND owner", groupId).Count(&owners).Error
+	if err != nil {
+		return err
+	}
+	if owners == 0 {
+		return ErrLastGroupOwner
+	}
+	return nil
+}
+
+func (db DataBase) SubscribeToGroup(userThatSubscibesId, groupId int) error {
+	err := db.DB.First(&types.Group{}, groupId).Error
+	if err != nil {
+		return err
+	}
+	var count int64
+	err = db.DB.Model(&types.GroupSubscription{}).Where("subscriber_id = ? AND group_id = ?", userThatSubscibesId, groupId).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("already subscribed")
+	}
+	return db.DB.Create(&types.GroupSubscription{SubscriberID: userThatSubscibesId, GroupID: groupId}).Error
+}
+
+func (db DataBase) UnsubscribeFromGroup(userThatSubscibesId, groupId int) error {
+	err := db.DB.First(&types.Group{}, groupId).Error
+	if err != nil {
+		return err
+	}
+	return db.DB.Where("subscriber_id = ? AND group_id = ?", userThatSubscibesId, groupId).Delete(&types.GroupSubscription{}).Error
+}
+
+// GetSubscribedGroups returns the groups the user is subscribed to.
+func (db DataBase) GetSubscribedGroups(userThatSubscibesId int) ([]types.Group, error) {
+	groups := []types.Group{}
+	err := db.DB.Where("id IN (?)", db.DB.Model(&types.GroupSubscription{}).Select("group_id").Where("subscriber_id = ?", userThatSubscibesId)).
+		Order("id ASC").Find(&groups).Error
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
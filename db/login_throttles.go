@@ -0,0 +1,176 @@
+package db
+
+import (
+	"strings"
+	"time"
+
+	"birthday/types"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// failureWindow is how long failed logins are remembered after the last
+	// one.
+	failureWindow   time.Duration = time.Hour
+	lockoutDuration time.Duration = 15 * time.Minute
+)
+
+// throttlePolicy delays every attempt after delayAfter failures, twice as
+// long after each new failure, and locks the subject out after lockAfter.
+type throttlePolicy struct {
+	delayAfter int
+	lockAfter  int
+}
+
+var throttlePolicies = map[string]throttlePolicy{
+	types.ThrottleAccount: {delayAfter: 3, lockAfter: 10},
+	// Many users may share an address behind a NAT.
+	types.ThrottleIP: {delayAfter: 20, lockAfter: 100},
+	// Every reset request sends an email and every reset costs a password
+	// hash, legitimate users need only a few.
+	types.ThrottlePasswordReset:     {delayAfter: 5, lockAfter: 20},
+	types.ThrottleEmailVerification: {delayAfter: 5, lockAfter: 20},
+}
+
+func (p throttlePolicy) block(failures int) time.Duration {
+	switch {
+	case failures >= p.lockAfter:
+		return lockoutDuration
+	case failures <= p.delayAfter:
+		return 0
+	}
+	// Shifting by 34 or more would overflow, and the delay passes the
+	// lockout long before that anyway.
+	if n := failures - p.delayAfter - 1; n < 20 {
+		return min(time.Second<<n, lockoutDuration)
+	}
+	return lockoutDuration
+}
+
+// accountSubject normalizes emails, so changing their case doesn't get
+// around the throttling.
+func accountSubject(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// LoginBlockedUntil returns until when logins to email or from ip are
+// blocked, a zero time if they aren't.
+func (db DataBase) LoginBlockedUntil(email, ip string) (time.Time, error) {
+	var throttles []types.LoginThrottle
+	err := db.DB.Where("(kind = ? AND subject = ?) OR (kind = ? AND subject = ?)",
+		types.ThrottleAccount, accountSubject(email), types.ThrottleIP, ip).Find(&throttles).Error
+	if err != nil {
+		return time.Time{}, err
+	}
+	var blockedUntil time.Time
+	for _, throttle := range throttles {
+		if throttle.BlockedUntil.After(blockedUntil) {
+			blockedUntil = throttle.BlockedUntil
+		}
+	}
+	return blockedUntil, nil
+}
+
+// RecordLoginFailure counts a failed login to email from ip. The counters
+// are incremented in the database, so concurrent attempts against several
+// replicas are all counted.
+func (db DataBase) RecordLoginFailure(email, ip string) error {
+	now := time.Now()
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		for kind, subject := range map[string]string{types.ThrottleAccount: accountSubject(email), types.ThrottleIP: ip} {
+			err := recordFailure(tx, kind, subject, now)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func recordFailure(tx *gorm.DB, kind, subject string, now time.Time) error {
+	err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&types.LoginThrottle{Kind: kind, Subject: subject, LastFailureAt: now}).Error
+	if err != nil {
+		return err
+	}
+	throttle := tx.Model(&types.LoginThrottle{}).Where("kind = ? AND subject = ?", kind, subject)
+	err = throttle.Session(&gorm.Session{}).Where("last_failure_at < ?", now.Add(-failureWindow)).
+		Update("failures", 0).Error
+	if err != nil {
+		return err
+	}
+	err = throttle.Session(&gorm.Session{}).Updates(map[string]any{
+		"failures":        gorm.Expr("failures + 1"),
+		"last_failure_at": now,
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	var failures int
+	err = throttle.Session(&gorm.Session{}).Pluck("failures", &failures).Error
+	if err != nil {
+		return err
+	}
+	return throttle.Session(&gorm.Session{}).Update("blocked_until", now.Add(throttlePolicies[kind].block(failures))).Error
+}
+
+// PasswordResetBlockedUntil returns until when password resets from ip are
+// blocked, a zero time if they aren't.
+func (db DataBase) PasswordResetBlockedUntil(ip string) (time.Time, error) {
+	return db.blockedUntil(types.ThrottlePasswordReset, ip)
+}
+
+// RecordPasswordResetAttempt counts a password reset request, or a reset
+// with an invalid token, from ip.
+func (db DataBase) RecordPasswordResetAttempt(ip string) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		return recordFailure(tx, types.ThrottlePasswordReset, ip, time.Now())
+	})
+}
+
+// VerificationEmailBlockedUntil returns until when requests for a new email
+// verification token from ip are blocked, a zero time if they aren't.
+func (db DataBase) VerificationEmailBlockedUntil(ip string) (time.Time, error) {
+	return db.blockedUntil(types.ThrottleEmailVerification, ip)
+}
+
+// RecordVerificationEmailRequest counts a request for a new email
+// verification token from ip.
+func (db DataBase) RecordVerificationEmailRequest(ip string) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		return recordFailure(tx, types.ThrottleEmailVerification, ip, time.Now())
+	})
+}
+
+func (db DataBase) blockedUntil(kind, subject string) (time.Time, error) {
+	var throttles []types.LoginThrottle
+	err := db.DB.Where("kind = ? AND subject = ?", kind, subject).Find(&throttles).Error
+	if err != nil || len(throttles) == 0 {
+		return time.Time{}, err
+	}
+	return throttles[0].BlockedUntil, nil
+}
+
+// ClearLoginFailures forgets the failed logins to email, after a successful
+// one or when an admin unlocks the account. Failures from IP addresses are
+// kept, logging into one account mustn't help guessing the passwords of
+// others.
+func (db DataBase) ClearLoginFailures(email string) error {
+	return clearLoginFailures(db.DB, email)
+}
+
+func clearLoginFailures(tx *gorm.DB, email string) error {
+	return tx.Where("kind = ? AND subject = ?", types.ThrottleAccount, accountSubject(email)).Delete(&types.LoginThrottle{}).Error
+}
+
+func (db DataBase) UnlockUser(id int) error {
+	var user types.BirthdayUser
+	err := db.DB.Select("email").First(&user, id).Error
+	if err != nil {
+		return err
+	}
+	return db.ClearLoginFailures(user.Email)
+}
@@ -0,0 +1,30 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThrottleBlock(t *testing.T) {
+	tests := []struct {
+		policy   throttlePolicy
+		failures int
+		want     time.Duration
+	}{
+		{throttlePolicy{delayAfter: 3, lockAfter: 10}, 3, 0},
+		{throttlePolicy{delayAfter: 3, lockAfter: 10}, 4, time.Second},
+		{throttlePolicy{delayAfter: 3, lockAfter: 10}, 6, 4 * time.Second},
+		{throttlePolicy{delayAfter: 3, lockAfter: 10}, 10, lockoutDuration},
+		{throttlePolicy{delayAfter: 20, lockAfter: 100}, 30, 512 * time.Second},
+		{throttlePolicy{delayAfter: 20, lockAfter: 100}, 31, lockoutDuration},
+		// Without a limit on the shift these wrap around to no delay at all.
+		{throttlePolicy{delayAfter: 20, lockAfter: 100}, 55, lockoutDuration},
+		{throttlePolicy{delayAfter: 20, lockAfter: 100}, 85, lockoutDuration},
+		{throttlePolicy{delayAfter: 20, lockAfter: 100}, 99, lockoutDuration},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.block(tt.failures); got != tt.want {
+			t.Errorf("%+v: after %d failures got %s, want %s", tt.policy, tt.failures, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"birthday/types"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPageSize int = 10
+	maxPageSize     int = 100
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// cursor points right after the last item of a page. It is handed to clients
+// as an opaque string and carries the sort order it was issued for, so it
+// can't be replayed against a different ordering.
+type cursor struct {
+	SortBy     string `json:"s,omitempty"`
+	Descending bool   `json:"d,omitempty"`
+	Key        string `json:"k,omitempty"`
+	ID         int    `json:"i"`
+}
+
+func (c cursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(value string) (cursor, error) {
+	var c cursor
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+func pageNumbers(r *http.Request) (int, int) {
+	q := r.URL.Query()
+	page, _ := strconv.Atoi(q.Get("page"))
+	if page <= 0 {
+		page = 1
+	}
+
+	pageSize, _ := strconv.Atoi(q.Get("page_size"))
+	switch {
+	case pageSize > maxPageSize:
+		pageSize = maxPageSize
+	case pageSize <= 0:
+		pageSize = defaultPageSize
+	}
+	return page, pageSize
+}
+
+// userOrder describes how a list of users is ordered. sortKey is an SQL
+// expression producing a string, or empty to order by id alone. The id is
+// always the last key so the order is total and cursors are unambiguous.
+type userOrder struct {
+	sortBy     string
+	sortKey    string
+	descending bool
+}
+
+type keyedUser struct {
+	types.BirthdayUserResponse
+	SortKey string
+}
+
+// paginateUsers loads one page of the users selected by tx, a query on the
+// birthday_users table, as seen by viewer. A cursor query parameter switches from page numbers
+// to keyset pagination, which stays fast however deep the client goes.
+func (db DataBase) paginateUsers(tx *gorm.DB, order userOrder, viewer types.Viewer, r *http.Request) (types.Page[types.BirthdayUserResponse], error) {
+	page, pageSize := pageNumbers(r)
+	result := types.Page[types.BirthdayUserResponse]{Items: []types.BirthdayUserResponse{}, PageSize: pageSize}
+
+	// An empty cursor asks for the first page in cursor mode.
+	cursorMode := r.URL.Query().Has("cursor")
+	var after *cursor
+	if value := r.URL.Query().Get("cursor"); value != "" {
+		c, err := decodeCursor(value)
+		if err != nil {
+			return result, err
+		}
+		if c.SortBy != order.sortBy || c.Descending != order.descending {
+			return result, ErrInvalidCursor
+		}
+		after = &c
+	}
+	if !cursorMode {
+		result.Page = page
+	}
+
+	tx = tx.Scopes(visibleTo(viewer))
+	err := tx.Session(&gorm.Session{}).Count(&result.TotalCount).Error
+	if err != nil {
+		return result, err
+	}
+
+	direction, comparison := " ASC", " > "
+	if order.descending {
+		direction, comparison = " DESC", " < "
+	}
+	columns := "birthday_users.*"
+	if order.sortKey != "" {
+		columns += ", " + order.sortKey + " AS sort_key"
+		tx = tx.Order(order.sortKey + direction)
+	}
+	tx = tx.Select(columns).Order("birthday_users.id" + direction)
+
+	if cursorMode {
+		if after != nil && order.sortKey != "" {
+			tx = tx.Where("("+order.sortKey+comparison+"? OR ("+order.sortKey+" = ? AND birthday_users.id"+comparison+"?))", after.Key, after.Key, after.ID)
+		} else if after != nil {
+			tx = tx.Where("birthday_users.id"+comparison+"?", after.ID)
+		}
+		// One extra row tells whether there is a next page.
+		tx = tx.Limit(pageSize + 1)
+	} else {
+		tx = tx.Offset((page - 1) * pageSize).Limit(pageSize)
+	}
+
+	var rows []keyedUser
+	err = tx.Find(&rows).Error
+	if err != nil {
+		return result, err
+	}
+
+	hasMore := int64((page-1)*pageSize+len(rows)) < result.TotalCount
+	if cursorMode {
+		hasMore = len(rows) > pageSize
+		if hasMore {
+			rows = rows[:pageSize]
+		}
+	}
+	for _, row := range rows {
+		result.Items = append(result.Items, viewer.Redact(row.BirthdayUserResponse))
+	}
+	if hasMore && len(rows) > 0 {
+		last := rows[len(rows)-1]
+		result.NextCursor = cursor{SortBy: order.sortBy, Descending: order.descending, Key: last.SortKey, ID: last.ID}.encode()
+	}
+	return result, nil
+}
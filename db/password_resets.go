@@ -0,0 +1,87 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"birthday/types"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+var ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+
+// CreatePasswordResetToken stores a new reset token for the user. Tokens sent
+// earlier stop working, only the latest email can be used.
+func (db DataBase) CreatePasswordResetToken(userId int, tokenHash string, expiresAt time.Time) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		err := deletePasswordResetTokens(tx, userId)
+		if err != nil {
+			return err
+		}
+		return tx.Create(&types.PasswordResetToken{UserID: userId, TokenHash: tokenHash, ExpiresAt: expiresAt}).Error
+	})
+}
+
+// ResetPassword uses up the reset token and sets the password of its user.
+// The sessions and API keys of the user are revoked, so whoever knew the old
+// password is logged out and can't keep using a key they created. The token
+// is checked before the password is hashed, guessing tokens mustn't cost a
+// hash each.
+func (db DataBase) ResetPassword(tokenHash, password string) error {
+	var resetToken types.PasswordResetToken
+	err := db.DB.Where("token_hash = ?", tokenHash).First(&resetToken).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidResetToken
+		}
+		return err
+	}
+	if resetToken.UsedAt != nil || time.Now().After(resetToken.ExpiresAt) {
+		return ErrInvalidResetToken
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), PasswordHashCost)
+	if err != nil {
+		return err
+	}
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		// Used up or replaced while hashing.
+		result := tx.Model(&resetToken).Where("used_at IS NULL AND expires_at > ?", now).Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidResetToken
+		}
+		var user types.BirthdayUser
+		err := tx.Select("email").First(&user, resetToken.UserID).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&types.BirthdayUser{}).Where("id = ?", resetToken.UserID).Update("password", string(hashedPassword)).Error
+		if err != nil {
+			return err
+		}
+		// Whoever locked the account out can't get in with the new password.
+		err = clearLoginFailures(tx, user.Email)
+		if err != nil {
+			return err
+		}
+		err = tx.Where("user_id = ?", resetToken.UserID).Delete(&types.APIKey{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&types.Session{}).
+			Where("user_id = ? AND revoked_at IS NULL", resetToken.UserID).
+			Update("revoked_at", now).Error
+	})
+}
+
+// deletePasswordResetTokens makes the reset tokens sent to the user so far
+// stop working, they went to an email the user no longer has.
+func deletePasswordResetTokens(tx *gorm.DB, userId int) error {
+	return tx.Where("user_id = ? AND used_at IS NULL", userId).Delete(&types.PasswordResetToken{}).Error
+}
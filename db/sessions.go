@@ -0,0 +1,95 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"birthday/types"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token has already been used, the session is revoked")
+)
+
+func (db DataBase) CreateSession(userId int, refreshTokenHash string, expiresAt time.Time) (types.Session, error) {
+	session := types.Session{UserID: userId}
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(&session).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&types.RefreshToken{SessionID: session.ID, TokenHash: refreshTokenHash, ExpiresAt: expiresAt}).Error
+	})
+	if err != nil {
+		return types.Session{}, err
+	}
+	return session, nil
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one within the same
+// session. Presenting an already used token means it has leaked, so the whole
+// session is revoked.
+func (db DataBase) RotateRefreshToken(oldHash, newHash string, expiresAt time.Time) (types.Session, error) {
+	var session types.Session
+	reused := false
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var refreshToken types.RefreshToken
+		err := tx.Where("token_hash = ?", oldHash).First(&refreshToken).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidRefreshToken
+			}
+			return err
+		}
+		err = tx.First(&session, refreshToken.SessionID).Error
+		if err != nil {
+			return err
+		}
+		if session.RevokedAt != nil {
+			return ErrInvalidRefreshToken
+		}
+
+		now := time.Now()
+		if refreshToken.UsedAt != nil {
+			reused = true
+			return tx.Model(&session).Update("revoked_at", now).Error
+		}
+		if now.After(refreshToken.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+
+		result := tx.Model(&refreshToken).Where("used_at IS NULL").Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidRefreshToken
+		}
+		return tx.Create(&types.RefreshToken{SessionID: session.ID, TokenHash: newHash, ExpiresAt: expiresAt}).Error
+	})
+	if err != nil {
+		return types.Session{}, err
+	}
+	if reused {
+		return types.Session{}, ErrRefreshTokenReused
+	}
+	return session, nil
+}
+
+func (db DataBase) RevokeSession(userId, sessionId int) error {
+	return db.DB.Model(&types.Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionId, userId).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (db DataBase) IsSessionActive(sessionId int) (bool, error) {
+	var count int64
+	err := db.DB.Model(&types.Session{}).Where("id = ? AND revoked_at IS NULL", sessionId).Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,104 @@
+package db
+
+import (
+	"net/http"
+	"time"
+
+	"birthday/types"
+)
+
+// Store is everything the API and the notification scheduler need from the
+// storage layer. DataBase implements it on top of Postgres, SQLite or an
+// in-memory SQLite database depending on the configured driver. There is no
+// separate map-backed implementation: the memory driver runs the same SQL as
+// the sqlite one, only without a file.
+type Store interface {
+	Migrate() error
+
+	GetUsers(viewer types.Viewer, query types.UserQuery, r *http.Request) (types.Page[types.BirthdayUserResponse], error)
+	StreamUsers(viewer types.Viewer, fn func(types.BirthdayUserResponse) error) error
+	CreateUser(user types.BirthdayUser) (types.BirthdayUserResponse, error)
+	ImportUsers(users []types.BirthdayUser, dryRun, atomic bool) ([]types.ImportResult, error)
+	GetUser(id int) (types.BirthdayUserResponse, error)
+	GetVisibleUser(viewer types.Viewer, id int) (types.BirthdayUserResponse, error)
+	GetUserByEmail(email string) (types.BirthdayUser, error)
+	UpdateUser(id int, newUser types.BirthdayUserRequest) (types.BirthdayUserResponse, error)
+	PatchUser(id int, newUser types.BirthdayUserRequest) (types.BirthdayUserResponse, error)
+	DeleteUser(id int) error
+	GetUserDataExport(id int) (types.UserDataExport, error)
+	GetUserRole(id int) (string, error)
+	SetUserRole(id int, role string) error
+	IsEmailVerified(id int) (bool, error)
+	GetPrivacySettings(id int) (types.PrivacySettings, error)
+	UpdatePrivacySettings(id int, settings types.PrivacySettings) error
+
+	SubscribeToUser(userThatSubscibesId, userToSubscribeid int, reminders types.ReminderOffsets) error
+	UnSubscribeFromUser(userThatSubscibesId, userToSubscribeid int) error
+	RequestSubscription(userThatSubscibesId, userToSubscribeid int, reminders types.ReminderOffsets) (bool, error)
+	GetSubscriptionSettings(userThatSubscibesId, userToSubscribeid int) (types.UserSubscription, error)
+	UpdateSubscriptionSettings(userThatSubscibesId, userToSubscribeid int, settings types.SubscriptionSettings) (types.UserSubscription, error)
+	GetSubscriptionRequests(userId int) ([]types.SubscriptionRequest, error)
+	ApproveSubscriptionRequest(userId, id int) error
+	RejectSubscriptionRequest(userId, id int) error
+
+	GetGroups() ([]types.Group, error)
+	CreateGroup(ownerId int, group types.Group) (types.Group, error)
+	GetGroup(viewer types.Viewer, id int) (types.GroupDetails, error)
+	DeleteGroup(id int) error
+	IsGroupOwner(groupId, userId int) (bool, error)
+	SetGroupMember(groupId int, member types.GroupMemberRequest) error
+	RemoveGroupMember(groupId, userId int) error
+	SubscribeToGroup(userThatSubscibesId, groupId int) error
+	UnsubscribeFromGroup(userThatSubscibesId, groupId int) error
+	GetSubscribedGroups(userThatSubscibesId int) ([]types.Group, error)
+	GetBirthdays(userThatSubscibesId int, r *http.Request) (types.Page[types.BirthdayUserResponse], error)
+	GetUpcomingBirthdays(userThatSubscibesId int, today, from, to time.Time) ([]types.UpcomingBirthday, error)
+	GetSubscriptions(userThatSubscibesId int, r *http.Request) (types.Page[types.BirthdayUserResponse], error)
+	GetAllSubscriptions(userThatSubscibesId int) ([]types.BirthdayUserResponse, error)
+	StreamSubscriptions(userThatSubscibesId int, fn func(types.BirthdayUserResponse) error) error
+	SetCalendarToken(userId int, tokenHash string) error
+	GetUserIdByCalendarToken(tokenHash string) (int, error)
+
+	CreateSession(userId int, refreshTokenHash string, expiresAt time.Time) (types.Session, error)
+	RotateRefreshToken(oldHash, newHash string, expiresAt time.Time) (types.Session, error)
+	RevokeSession(userId, sessionId int) error
+	IsSessionActive(sessionId int) (bool, error)
+	CreatePasswordResetToken(userId int, tokenHash string, expiresAt time.Time) error
+	ResetPassword(tokenHash, password string) error
+	CreateEmailVerificationToken(userId int, email, tokenHash string, expiresAt time.Time) error
+	VerifyEmail(tokenHash string) error
+	LoginBlockedUntil(email, ip string) (time.Time, error)
+	RecordLoginFailure(email, ip string) error
+	ClearLoginFailures(email string) error
+	UnlockUser(id int) error
+	PasswordResetBlockedUntil(ip string) (time.Time, error)
+	RecordPasswordResetAttempt(ip string) error
+	VerificationEmailBlockedUntil(ip string) (time.Time, error)
+	RecordVerificationEmailRequest(ip string) error
+	CreateAPIKey(key types.APIKey) (types.APIKey, error)
+	GetAPIKeys(userId int) ([]types.APIKey, error)
+	RevokeAPIKey(userId, id int) error
+	AuthenticateAPIKey(keyHash string) (types.APIKey, error)
+
+	GetTimezones() ([]string, error)
+	GetReminderOffsets() (types.ReminderOffsets, error)
+	GetPendingNotifications(timezone string, date time.Time, offsets types.ReminderOffsets) ([]types.PendingNotification, error)
+	RecordNotifications(subscriberId int, birthdayUserIds []int, date time.Time) error
+	GetDeliveryPreferences(id int) (types.DeliveryPreferences, error)
+	UpdateDeliveryPreferences(id int, preferences types.DeliveryPreferences) error
+	GetDigestSubscribers(timezone, delivery string, hour int, date time.Time) ([]types.BirthdayUserResponse, error)
+	GetDigestBirthdays(subscriberId int, from, to time.Time) ([]types.UpcomingBirthday, error)
+	RecordDigest(subscriberId int, delivery string, date time.Time) error
+
+	GetWebhooks(userId int) ([]types.Webhook, error)
+	GetWebhook(userId, id int) (types.Webhook, error)
+	CreateWebhook(webhook types.Webhook) (types.Webhook, error)
+	UpdateWebhook(userId, id int, newWebhook types.WebhookRequest) (types.Webhook, error)
+	DeleteWebhook(userId, id int) error
+	GetWebhookDeliveries(userId, webhookId int, r *http.Request) ([]types.WebhookDelivery, error)
+	GetActiveWebhooks(userId int) ([]types.Webhook, error)
+	RecordWebhookDelivery(delivery types.WebhookDelivery) error
+	MarkWebhookResult(webhookId int, success bool, disableAfter int) (bool, error)
+}
+
+var _ Store = DataBase{}
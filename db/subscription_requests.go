@@ -0,0 +1,127 @@
+package db
+
+import (
+	"errors"
+
+	"birthday/types"
+
+	"gorm.io/gorm"
+)
+
+var ErrSubscriptionRequested = errors.New("subscription already requested")
+
+// RequestSubscription subscribes right away unless the user to subscribe to
+// requires approval or hides their profile from some users, in which case it
+// records a pending request and returns true.
+func (db DataBase) RequestSubscription(userThatSubscibesId, userToSubscribeid int, reminders types.ReminderOffsets) (bool, error) {
+	var userToSubscribe types.BirthdayUser
+	err := db.DB.First(&userToSubscribe, userToSubscribeid).Error
+	if err != nil {
+		return false, err
+	}
+	if !userToSubscribe.ApprovalRequired() {
+		return false, db.SubscribeToUser(userThatSubscibesId, userToSubscribeid, reminders)
+	}
+
+	var userThatSubscribes types.BirthdayUser
+	err = db.DB.First(&userThatSubscribes, userThatSubscibesId).Error
+	if err != nil {
+		return false, err
+	}
+	if db.subscribed(db.DB, userThatSubscibesId, userToSubscribeid) {
+		return false, errors.New("already subscribed")
+	}
+	var requests int64
+	err = db.DB.Model(&types.SubscriptionRequest{}).Where("subscriber_id = ? AND user_id = ?", userThatSubscibesId, userToSubscribeid).Count(&requests).Error
+	if err != nil {
+		return false, err
+	}
+	if requests > 0 {
+		return false, ErrSubscriptionRequested
+	}
+
+	err = db.DB.Create(&types.SubscriptionRequest{
+		SubscriberID:    userThatSubscibesId,
+		UserID:          userToSubscribeid,
+		ReminderOffsets: reminderOffsets(reminders),
+	}).Error
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (db DataBase) subscribed(tx *gorm.DB, userThatSubscibesId, userToSubscribeid int) bool {
+	var count int64
+	tx.Table(SUBSCRIPTIONS_TABLE).Where("birthday_user_id = ? AND "+THROUGH_MANY_TO_MANY_TABLE_SECOND_COLUMN+" = ?", userThatSubscibesId, userToSubscribeid).Count(&count)
+	return count > 0
+}
+
+// GetSubscriptionRequests returns the pending requests to subscribe to the
+// user, oldest first, together with what the user may see of the requesters.
+func (db DataBase) GetSubscriptionRequests(userId int) ([]types.SubscriptionRequest, error) {
+	var requests []types.SubscriptionRequest
+	err := db.DB.Where("user_id = ?", userId).Order("id ASC").Find(&requests).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(requests) == 0 {
+		return requests, nil
+	}
+
+	ids := make([]int, 0, len(requests))
+	for _, request := range requests {
+		ids = append(ids, request.SubscriberID)
+	}
+	var subscribers []types.BirthdayUserResponse
+	err = db.DB.Model(&types.BirthdayUser{}).Where("id IN ?", ids).Find(&subscribers).Error
+	if err != nil {
+		return nil, err
+	}
+	viewer := types.Viewer{ID: userId}
+	subscribersById := make(map[int]types.BirthdayUserResponse, len(subscribers))
+	for _, subscriber := range subscribers {
+		subscribersById[subscriber.ID] = viewer.Redact(subscriber)
+	}
+	for i := range requests {
+		if subscriber, ok := subscribersById[requests[i].SubscriberID]; ok {
+			requests[i].Subscriber = &subscriber
+		}
+	}
+	return requests, nil
+}
+
+// ApproveSubscriptionRequest turns a pending request to subscribe to the user
+// into a subscription.
+func (db DataBase) ApproveSubscriptionRequest(userId, id int) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		var request types.SubscriptionRequest
+		err := tx.Where("user_id = ?", userId).First(&request, id).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Delete(&request).Error
+		if err != nil {
+			return err
+		}
+		if db.subscribed(tx, request.SubscriberID, request.UserID) {
+			return nil
+		}
+		return tx.Create(&types.UserSubscription{
+			BirthdayUserID:  request.SubscriberID,
+			SubscriptionID:  request.UserID,
+			ReminderOffsets: reminderOffsets(request.ReminderOffsets),
+		}).Error
+	})
+}
+
+func (db DataBase) RejectSubscriptionRequest(userId, id int) error {
+	result := db.DB.Where("user_id = ?", userId).Delete(&types.SubscriptionRequest{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
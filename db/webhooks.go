@@ -0,0 +1,127 @@
+package db
+
+import (
+	"net/http"
+	"time"
+
+	"birthday/types"
+
+	"gorm.io/gorm"
+)
+
+func (db DataBase) GetWebhooks(userId int) ([]types.Webhook, error) {
+	var webhooks []types.Webhook
+	err := db.DB.Where("user_id = ?", userId).Order("id ASC").Find(&webhooks).Error
+	if err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+func (db DataBase) GetWebhook(userId, id int) (types.Webhook, error) {
+	var webhook types.Webhook
+	err := db.DB.Where("user_id = ?", userId).First(&webhook, id).Error
+	if err != nil {
+		return types.Webhook{}, err
+	}
+	return webhook, nil
+}
+
+func (db DataBase) CreateWebhook(webhook types.Webhook) (types.Webhook, error) {
+	err := db.DB.Create(&webhook).Error
+	if err != nil {
+		return types.Webhook{}, err
+	}
+	return webhook, nil
+}
+
+// UpdateWebhook only writes the columns that change, deliveries update the
+// failure counter of the same row concurrently.
+func (db DataBase) UpdateWebhook(userId, id int, newWebhook types.WebhookRequest) (types.Webhook, error) {
+	webhook, err := db.GetWebhook(userId, id)
+	if err != nil {
+		return types.Webhook{}, err
+	}
+	changes := map[string]any{}
+	if newWebhook.URL != "" {
+		changes["url"] = newWebhook.URL
+	}
+	if newWebhook.Enabled != nil {
+		if *newWebhook.Enabled && !webhook.Enabled {
+			changes["consecutive_failures"] = 0
+			changes["disabled_at"] = nil
+		}
+		changes["enabled"] = *newWebhook.Enabled
+	}
+	if len(changes) == 0 {
+		return webhook, nil
+	}
+	err = db.DB.Model(&types.Webhook{}).Where("id = ? AND user_id = ?", id, userId).Updates(changes).Error
+	if err != nil {
+		return types.Webhook{}, err
+	}
+	return db.GetWebhook(userId, id)
+}
+
+func (db DataBase) DeleteWebhook(userId, id int) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("user_id = ?", userId).Delete(&types.Webhook{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Where("webhook_id = ?", id).Delete(&types.WebhookDelivery{}).Error
+	})
+}
+
+func (db DataBase) GetWebhookDeliveries(userId, webhookId int, r *http.Request) ([]types.WebhookDelivery, error) {
+	_, err := db.GetWebhook(userId, webhookId)
+	if err != nil {
+		return nil, err
+	}
+	var deliveries []types.WebhookDelivery
+	err = db.DB.Where("webhook_id = ?", webhookId).Scopes(Paginate(r)).Order("id DESC").Find(&deliveries).Error
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+func (db DataBase) GetActiveWebhooks(userId int) ([]types.Webhook, error) {
+	var webhooks []types.Webhook
+	err := db.DB.Where("user_id = ? AND enabled = ?", userId, true).Find(&webhooks).Error
+	if err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+func (db DataBase) RecordWebhookDelivery(delivery types.WebhookDelivery) error {
+	return db.DB.Create(&delivery).Error
+}
+
+// MarkWebhookResult changes nothing but the counter and the enabled flag, in
+// single statements, as deliveries to the same webhook may run concurrently
+// with each other and with the user editing it. Only the call that disables
+// the webhook reports it.
+func (db DataBase) MarkWebhookResult(webhookId int, success bool, disableAfter int) (bool, error) {
+	webhook := db.DB.Model(&types.Webhook{}).Where("id = ?", webhookId)
+	if success {
+		return false, webhook.Update("consecutive_failures", 0).Error
+	}
+	result := webhook.Session(&gorm.Session{}).Update("consecutive_failures", gorm.Expr("consecutive_failures + 1"))
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, gorm.ErrRecordNotFound
+	}
+	result = webhook.Session(&gorm.Session{}).Where("enabled AND consecutive_failures >= ?", disableAfter).
+		Updates(map[string]any{"enabled": false, "disabled_at": time.Now()})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
@@ -0,0 +1,55 @@
+package db
+
+import (
+	"sync"
+	"testing"
+
+	"birthday/types"
+)
+
+func TestMarkWebhookResultConcurrently(t *testing.T) {
+	database := newTestDataBase(t)
+	ann := createTestUser(t, database, "ann")
+	webhook, err := database.CreateWebhook(types.Webhook{UserID: ann, URL: "https://example.com/hook", Secret: "secret", Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const deliveries = 20
+	var wg sync.WaitGroup
+	disabled := make(chan bool, deliveries)
+	for i := 0; i < deliveries; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d, err := database.MarkWebhookResult(webhook.ID, false, 5)
+			if err != nil {
+				t.Error(err)
+			}
+			disabled <- d
+		}()
+	}
+	_, err = database.UpdateWebhook(ann, webhook.ID, types.WebhookRequest{URL: "https://example.com/new"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	close(disabled)
+
+	var disabling int
+	for d := range disabled {
+		if d {
+			disabling++
+		}
+	}
+	if disabling != 1 {
+		t.Errorf("%d deliveries reported disabling the webhook, want 1", disabling)
+	}
+	webhook, err = database.GetWebhook(ann, webhook.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if webhook.ConsecutiveFailures != deliveries || webhook.Enabled || webhook.URL != "https://example.com/new" {
+		t.Errorf("got %+v, want %d failures, disabled and the new URL", webhook, deliveries)
+	}
+}
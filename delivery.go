@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"birthday/types"
+)
+
+func validateDeliveryPreferences(preferences types.DeliveryPreferences) error {
+	switch preferences.Delivery {
+	case types.DeliveryInstant, types.DeliveryDaily, types.DeliveryWeekly:
+	default:
+		return errors.New("delivery must be one of instant, daily, weekly")
+	}
+	if preferences.DigestHour < 0 || preferences.DigestHour > 23 {
+		return errors.New("digestHour must be between 0 and 23")
+	}
+	return nil
+}
+
+func (na *NotifyApp) deliveryPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := currentUserId(w, r)
+	if err != nil {
+		return
+	}
+	preferences, err := na.dbConnection.GetDeliveryPreferences(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if r.Method == http.MethodGet {
+		respondWithJSON(w, http.StatusOK, preferences)
+		return
+	}
+
+	// PATCH decodes over the current preferences, PUT replaces all of them.
+	if r.Method == http.MethodPut {
+		preferences = types.DeliveryPreferences{}
+	}
+	err = json.NewDecoder(r.Body).Decode(&preferences)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer r.Body.Close()
+
+	err = validateDeliveryPreferences(preferences)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	err = na.dbConnection.UpdateDeliveryPreferences(userId, preferences)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, preferences)
+}
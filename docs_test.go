@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// TestSpecCoversRoutes keeps docs/swagger.json, which /docs serves, in step
+// with the router.
+func TestSpecCoversRoutes(t *testing.T) {
+	file, err := os.ReadFile("docs/swagger.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var spec struct {
+		Paths       map[string]map[string]json.RawMessage `json:"paths"`
+		Definitions map[string]json.RawMessage            `json:"definitions"`
+	}
+	err = json.Unmarshal(file, &spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := newTestApp(t)
+	variable := regexp.MustCompile(`\{(\w+):[^}]*\}`)
+	documented := map[string]bool{}
+	err = app.Router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			// Only the /docs prefix has no methods.
+			return nil
+		}
+		path := variable.ReplaceAllString(template, "{$1}")
+		for _, method := range methods {
+			method = strings.ToLower(method)
+			documented[method+" "+path] = true
+			if _, ok := spec.Paths[path][method]; !ok {
+				t.Errorf("%s %s isn't documented", method, path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, operations := range spec.Paths {
+		for method := range operations {
+			if !documented[method+" "+path] {
+				t.Errorf("%s %s is documented but not routed", method, path)
+			}
+		}
+	}
+
+	for _, ref := range regexp.MustCompile(`"#/definitions/([^"]+)"`).FindAllSubmatch(file, -1) {
+		if _, ok := spec.Definitions[string(ref[1])]; !ok {
+			t.Errorf("missing definition %s", ref[1])
+		}
+	}
+}
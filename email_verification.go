@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"birthday/db"
+	"birthday/types"
+
+	"gorm.io/gorm"
+)
+
+const emailVerificationTokenTTL time.Duration = 24 * time.Hour
+
+func (na *NotifyApp) sendEmailVerification(user types.BirthdayUserResponse) error {
+	token, err := generateToken()
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(emailVerificationTokenTTL)
+	err = na.dbConnection.CreateEmailVerificationToken(user.ID, user.Email, hashToken(token), expiresAt)
+	if err != nil {
+		return err
+	}
+
+	instructions := "Send it to POST /api/auth/email/verify to confirm the address."
+	if verificationURL := os.Getenv(EMAIL_VERIFICATION_URL_ENV); verificationURL != "" {
+		instructions = "To confirm the address, open " + verificationURL + "?token=" + url.QueryEscape(token)
+	}
+	text, body := tokenEmail(user.FirstName, "Please confirm that this email address belongs to your birthday notifier account.", token, instructions, expiresAt)
+	return na.mailer.Send(user.Email, "Confirm your email address", text, body)
+}
+
+// verifyEmailInBackground sends the verification email without making the
+// request that created or changed the address wait for the mailer.
+func (na *NotifyApp) verifyEmailInBackground(user types.BirthdayUserResponse) {
+	go func() {
+		err := na.sendEmailVerification(user)
+		if err != nil {
+			log.Printf("email verification: failed to send verification email to user %d: %v\n", user.ID, err)
+		}
+	}()
+}
+
+func (na *NotifyApp) verifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var verifyRequest types.VerifyEmailRequest
+	err := json.NewDecoder(r.Body).Decode(&verifyRequest)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer r.Body.Close()
+
+	if verifyRequest.Token == "" {
+		respondWithError(w, http.StatusBadRequest, errors.New("token field is required"))
+		return
+	}
+
+	err = na.dbConnection.VerifyEmail(hashToken(verifyRequest.Token))
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrInvalidVerificationToken):
+			respondWithError(w, http.StatusBadRequest, err)
+		default:
+			respondWithError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "email verified")
+}
+
+// resendEmailVerificationHandler needs no token, users who may not log in
+// before verifying their email couldn't get a new one otherwise. Like
+// forgotPasswordHandler it answers the same way whether the email belongs to
+// an unverified account or not.
+func (na *NotifyApp) resendEmailVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	var resendRequest types.ResendVerificationRequest
+	err := json.NewDecoder(r.Body).Decode(&resendRequest)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer r.Body.Close()
+
+	if resendRequest.Email == "" {
+		respondWithError(w, http.StatusBadRequest, errors.New("email field is required"))
+		return
+	}
+	ip := na.clientIP(r)
+	if na.verificationEmailBlocked(w, ip) {
+		return
+	}
+	err = na.dbConnection.RecordVerificationEmailRequest(ip)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	go func() {
+		user, err := na.dbConnection.GetUserByEmail(resendRequest.Email)
+		if err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				log.Printf("email verification: failed to look up user: %v\n", err)
+			}
+			return
+		}
+		if user.EmailVerified {
+			return
+		}
+		err = na.sendEmailVerification(types.BirthdayUserResponse{ID: user.ID, BirthdayUserBase: user.BirthdayUserBase})
+		if err != nil {
+			log.Printf("email verification: failed to send verification email to user %d: %v\n", user.ID, err)
+		}
+	}()
+
+	respondWithJSON(w, http.StatusAccepted, "if the email belongs to an unverified account, a verification token has been sent to it")
+}
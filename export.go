@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"birthday/types"
+	"birthday/vcard"
+)
+
+const (
+	exportFormatCSV    string = "csv"
+	exportFormatNDJSON string = "ndjson"
+	exportFormatVCard  string = "vcard"
+
+	exportFlushEvery int = 100
+)
+
+// exportWriter encodes users one at a time so exports never have to hold
+// the whole result set in memory.
+type exportWriter interface {
+	Write(user types.BirthdayUserResponse) error
+	Flush() error
+}
+
+type csvExportWriter struct {
+	w *csv.Writer
+}
+
+func (e csvExportWriter) Write(user types.BirthdayUserResponse) error {
+	return e.w.Write([]string{strconv.Itoa(user.ID), csvText(user.FirstName), csvText(user.LastName), csvText(user.Email), user.BirthDate(), csvText(user.Timezone)})
+}
+
+// csvText keeps spreadsheets from running what users typed as a formula, by
+// prefixing the values they would take for one with a quote.
+func csvText(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func (e csvExportWriter) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type ndjsonExportWriter struct {
+	encoder *json.Encoder
+}
+
+func (e ndjsonExportWriter) Write(user types.BirthdayUserResponse) error {
+	return e.encoder.Encode(user)
+}
+
+func (e ndjsonExportWriter) Flush() error {
+	return nil
+}
+
+type vcardExportWriter struct {
+	w io.Writer
+}
+
+func (e vcardExportWriter) Write(user types.BirthdayUserResponse) error {
+	return vcard.Write(e.w, vcard.Card{
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		Email:     user.Email,
+		Birthday:  user.BirthDate(),
+	})
+}
+
+func (e vcardExportWriter) Flush() error {
+	return nil
+}
+
+func newExportWriter(w http.ResponseWriter, format, name string) (exportWriter, error) {
+	var contentType, extension string
+	var writer exportWriter
+	switch format {
+	case exportFormatCSV, "":
+		contentType, extension = "text/csv; charset=utf-8", "csv"
+		csvWriter := csv.NewWriter(w)
+		writer = csvExportWriter{csvWriter}
+		// csv.Writer buffers, the header reaches the client with the first flush.
+		csvWriter.Write([]string{"id", "firstName", "lastName", "email", "birthday", "timezone"})
+	case exportFormatNDJSON:
+		contentType, extension = "application/x-ndjson", "ndjson"
+		writer = ndjsonExportWriter{json.NewEncoder(w)}
+	case exportFormatVCard:
+		contentType, extension = "text/vcard; charset=utf-8", "vcf"
+		writer = vcardExportWriter{w}
+	default:
+		return nil, fmt.Errorf("unsupported format %q, expected %s, %s or %s", format, exportFormatCSV, exportFormatNDJSON, exportFormatVCard)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, name, extension))
+	w.WriteHeader(http.StatusOK)
+	return writer, nil
+}
+
+// streamExport writes every user produced by stream to the response,
+// flushing it to the client as it goes. Once the first byte is sent the
+// status can no longer change, so later errors are only logged.
+func streamExport(w http.ResponseWriter, r *http.Request, name string, stream func(func(types.BirthdayUserResponse) error) error) {
+	writer, err := newExportWriter(w, r.URL.Query().Get("format"), name)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	flusher, _ := w.(http.Flusher)
+
+	count := 0
+	err = stream(func(user types.BirthdayUserResponse) error {
+		err := writer.Write(user)
+		if err != nil {
+			return err
+		}
+		count++
+		if count%exportFlushEvery == 0 {
+			err = writer.Flush()
+			if err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		return r.Context().Err()
+	})
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil && !errors.Is(err, r.Context().Err()) {
+		log.Printf("export %s: %v\n", name, err)
+	}
+}
+
+func (na *NotifyApp) exportUsersHandler(w http.ResponseWriter, r *http.Request) {
+	viewer, err := na.viewer(r)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, err)
+		return
+	}
+	streamExport(w, r, "users", func(fn func(types.BirthdayUserResponse) error) error {
+		return na.dbConnection.StreamUsers(viewer, fn)
+	})
+}
+
+func (na *NotifyApp) exportSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := currentUserId(w, r)
+	if err != nil {
+		return
+	}
+	streamExport(w, r, "subscriptions", func(fn func(types.BirthdayUserResponse) error) error {
+		return na.dbConnection.StreamSubscriptions(userId, fn)
+	})
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"slices"
+	"testing"
+	"time"
+
+	"birthday/types"
+)
+
+func TestCSVExportEscapesFormulas(t *testing.T) {
+	tests := []struct {
+		firstName, lastName, email string
+		want                       []string
+	}{
+		{"Ann", "Lee", "ann@example.com", []string{"1", "Ann", "Lee", "ann@example.com", "1990-05-16", "UTC"}},
+		{"=HYPERLINK(\"http://evil.example\")", "+1", "@sum(a1)", []string{"1", "'=HYPERLINK(\"http://evil.example\")", "'+1", "'@sum(a1)", "1990-05-16", "UTC"}},
+		{"-2+3", "\tTab", "\rReturn", []string{"1", "'-2+3", "'\tTab", "'\rReturn", "1990-05-16", "UTC"}},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		writer := csvExportWriter{csv.NewWriter(&out)}
+		err := writer.Write(types.BirthdayUserResponse{ID: 1, BirthdayUserBase: types.BirthdayUserBase{
+			FirstName: tt.firstName, LastName: tt.lastName, Email: tt.email,
+			Birthday: time.Date(1990, time.May, 16, 0, 0, 0, 0, time.UTC), Timezone: "UTC",
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = writer.Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := csv.NewReader(&out).Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("wrote %q, want %q", got, tt.want)
+		}
+	}
+}
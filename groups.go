@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"birthday/db"
+	"birthday/types"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+const maxGroupNameLength int = 100
+
+func respondWithGroupError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		respondWithError(w, http.StatusNotFound, err)
+	case errors.Is(err, db.ErrLastGroupOwner):
+		respondWithError(w, http.StatusConflict, err)
+	default:
+		respondWithError(w, http.StatusInternalServerError, err)
+	}
+}
+
+func groupId(w http.ResponseWriter, r *http.Request) (int, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, errors.New("invalid group id"))
+	}
+	return id, err
+}
+
+// requireGroupOwner lets admins and the owners of the group through.
+func (na *NotifyApp) requireGroupOwner(w http.ResponseWriter, r *http.Request, userId, groupId int) bool {
+	if isAdmin(r) {
+		return true
+	}
+	owner, err := na.dbConnection.IsGroupOwner(groupId, userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err)
+		return false
+	}
+	if !owner {
+		respondWithError(w, http.StatusForbidden, errors.New("only group owners can manage the group"))
+		return false
+	}
+	return true
+}
+
+func (na *NotifyApp) getGroupsHandler(w http.ResponseWriter, r *http.Request) {
+	groups, err := na.dbConnection.GetGroups()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, groups)
+}
+
+func (na *NotifyApp) createGroupHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := currentUserId(w, r)
+	if err != nil {
+		return
+	}
+	var groupRequest types.GroupRequest
+	err = json.NewDecoder(r.Body).Decode(&groupRequest)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer r.Body.Close()
+
+	name := strings.TrimSpace(groupRequest.Name)
+	if name == "" || len(name) > maxGroupNameLength {
+		respondWithError(w, http.StatusBadRequest, errors.New("name field is required and must be at most 100 characters long"))
+		return
+	}
+	group, err := na.dbConnection.CreateGroup(userId, types.Group{Name: name})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, group)
+}
+
+func (na *NotifyApp) groupHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := currentUserId(w, r)
+	if err != nil {
+		return
+	}
+	id, err := groupId(w, r)
+	if err != nil {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		viewer, err := na.viewer(r)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, err)
+			return
+		}
+		group, err := na.dbConnection.GetGroup(viewer, id)
+		if err != nil {
+			respondWithGroupError(w, err)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, group)
+	case http.MethodDelete:
+		if !na.requireGroupOwner(w, r, userId, id) {
+			return
+		}
+		err = na.dbConnection.DeleteGroup(id)
+		if err != nil {
+			respondWithGroupError(w, err)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, "deleted group with id "+mux.Vars(r)["id"])
+	}
+}
+
+func (na *NotifyApp) setGroupMemberHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := currentUserId(w, r)
+	if err != nil {
+		return
+	}
+	id, err := groupId(w, r)
+	if err != nil {
+		return
+	}
+	if !na.requireGroupOwner(w, r, userId, id) {
+		return
+	}
+
+	var member types.GroupMemberRequest
+	err = json.NewDecoder(r.Body).Decode(&member)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer r.Body.Close()
+	if member.UserID <= 0 {
+		respondWithError(w, http.StatusBadRequest, errors.New("userId field is required"))
+		return
+	}
+
+	err = na.dbConnection.SetGroupMember(id, member)
+	if err != nil {
+		respondWithGroupError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "added user "+strconv.Itoa(member.UserID)+" to group with id "+mux.Vars(r)["id"])
+}
+
+// removeGroupMemberHandler lets owners remove anyone and members leave the
+// group themselves.
+func (na *NotifyApp) removeGroupMemberHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := currentUserId(w, r)
+	if err != nil {
+		return
+	}
+	id, err := groupId(w, r)
+	if err != nil {
+		return
+	}
+	vars := mux.Vars(r)
+	memberId, err := strconv.Atoi(vars["userId"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, errors.New("invalid user id"))
+		return
+	}
+	if memberId != userId && !na.requireGroupOwner(w, r, userId, id) {
+		return
+	}
+
+	err = na.dbConnection.RemoveGroupMember(id, memberId)
+	if err != nil {
+		respondWithGroupError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "removed user "+vars["userId"]+" from group with id "+vars["id"])
+}
+
+func (na *NotifyApp) subscribeToGroupHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := currentUserId(w, r)
+	if err != nil {
+		return
+	}
+	id, err := groupId(w, r)
+	if err != nil {
+		return
+	}
+
+	err = na.dbConnection.SubscribeToGroup(userId, id)
+	if err != nil {
+		switch {
+		case err.Error() == "already subscribed":
+			respondWithJSON(w, http.StatusOK, "already subscribed to group with id "+mux.Vars(r)["id"])
+		default:
+			respondWithGroupError(w, err)
+		}
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, "subscribed to group with id "+mux.Vars(r)["id"])
+}
+
+func (na *NotifyApp) unsubscribeFromGroupHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := currentUserId(w, r)
+	if err != nil {
+		return
+	}
+	id, err := groupId(w, r)
+	if err != nil {
+		return
+	}
+
+	err = na.dbConnection.UnsubscribeFromGroup(userId, id)
+	if err != nil {
+		respondWithGroupError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "unsubscribed from group with id "+mux.Vars(r)["id"])
+}
+
+func (na *NotifyApp) getSubscribedGroupsHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := currentUserId(w, r)
+	if err != nil {
+		return
+	}
+	groups, err := na.dbConnection.GetSubscribedGroups(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, groups)
+}
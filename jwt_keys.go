@@ -0,0 +1,244 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"birthday/types"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const minRSAKeyBits int = 2048
+
+type verificationKey struct {
+	key crypto.PublicKey
+	jwk types.JWK
+}
+
+// jwtKeys signs and verifies access tokens. Tokens are signed either with
+// the JWT_SECRET_KEY shared secret (HS256) or, so that other services can
+// verify them without sharing a secret, with the RS256 or EdDSA private key
+// in JWT_SIGNING_KEY. The public keys are published at
+// /.well-known/jwks.json, and the ones in JWT_VERIFICATION_KEYS keep
+// verifying tokens signed before a key was rotated.
+type jwtKeys struct {
+	method     jwt.SigningMethod
+	kid        string
+	signingKey any
+	// keys are the public keys tokens are verified with by kid, empty when
+	// signing with a shared secret.
+	keys     map[string]verificationKey
+	jwks     types.JWKS
+	methods  []string
+	issuer   string
+	audience string
+}
+
+func newJWTKeys() (*jwtKeys, error) {
+	keys := &jwtKeys{
+		keys:     map[string]verificationKey{},
+		jwks:     types.JWKS{Keys: []types.JWK{}},
+		issuer:   os.Getenv(JWT_ISSUER_ENV),
+		audience: os.Getenv(JWT_AUDIENCE_ENV),
+	}
+	if keys.issuer == "" {
+		keys.issuer = DEFAULT_JWT_ISSUER
+	}
+	if keys.audience == "" {
+		keys.audience = keys.issuer
+	}
+
+	signingKeyPath := os.Getenv(JWT_SIGNING_KEY_ENV)
+	if signingKeyPath == "" {
+		// Accepting other algorithms next to a shared secret would let
+		// anyone holding a public key forge tokens, so there are none.
+		secret := os.Getenv(JWT_SECRET_KEY_ENV)
+		if secret == "" {
+			return nil, fmt.Errorf("either %s or %s must be set", JWT_SIGNING_KEY_ENV, JWT_SECRET_KEY_ENV)
+		}
+		keys.method = jwt.SigningMethodHS256
+		keys.signingKey = []byte(secret)
+		keys.methods = []string{keys.method.Alg()}
+		return keys, nil
+	}
+
+	signingKey, err := readPEMKey(signingKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", JWT_SIGNING_KEY_ENV, err)
+	}
+	switch signingKey.(type) {
+	case *rsa.PrivateKey, ed25519.PrivateKey:
+	default:
+		return nil, fmt.Errorf("invalid %s: %s is not a private key", JWT_SIGNING_KEY_ENV, signingKeyPath)
+	}
+	keys.signingKey = signingKey
+	keys.kid, err = keys.add(signingKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", JWT_SIGNING_KEY_ENV, err)
+	}
+	keys.method = jwt.GetSigningMethod(keys.keys[keys.kid].jwk.Alg)
+
+	for _, path := range strings.Split(os.Getenv(JWT_VERIFICATION_KEYS_ENV), ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		key, err := readPEMKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", JWT_VERIFICATION_KEYS_ENV, err)
+		}
+		_, err = keys.add(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", JWT_VERIFICATION_KEYS_ENV, err)
+		}
+	}
+	return keys, nil
+}
+
+// readPEMKey reads a private or public key from a PEM file, as written by
+// openssl genpkey and openssl pkey -pubout.
+func readPEMKey(path string) (any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s contains no PEM encoded key", path)
+	}
+	var key any
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM block %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// add accepts tokens signed with the key, or the public half of it, and
+// returns its kid.
+func (k *jwtKeys) add(key any) (string, error) {
+	var jwk types.JWK
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return k.add(&key.PublicKey)
+	case ed25519.PrivateKey:
+		return k.add(key.Public())
+	case *rsa.PublicKey:
+		if key.N.BitLen() < minRSAKeyBits {
+			return "", fmt.Errorf("RSA keys must be at least %d bits long", minRSAKeyBits)
+		}
+		jwk = types.JWK{
+			Kty: "RSA",
+			Alg: jwt.SigningMethodRS256.Alg(),
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}
+		jwk.Kid = thumbprint(fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, jwk.E, jwk.N))
+	case ed25519.PublicKey:
+		jwk = types.JWK{
+			Kty: "OKP",
+			Alg: jwt.SigningMethodEdDSA.Alg(),
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(key),
+		}
+		jwk.Kid = thumbprint(fmt.Sprintf(`{"crv":"Ed25519","kty":"OKP","x":"%s"}`, jwk.X))
+	default:
+		return "", fmt.Errorf("unsupported key type %T, only RSA and Ed25519 keys are supported", key)
+	}
+	jwk.Use = "sig"
+
+	if _, ok := k.keys[jwk.Kid]; ok {
+		return jwk.Kid, nil
+	}
+	k.keys[jwk.Kid] = verificationKey{key: key, jwk: jwk}
+	k.jwks.Keys = append(k.jwks.Keys, jwk)
+	if !slices.Contains(k.methods, jwk.Alg) {
+		k.methods = append(k.methods, jwk.Alg)
+	}
+	return jwk.Kid, nil
+}
+
+// thumbprint is the RFC 7638 thumbprint of a key, given its required
+// members in lexicographic order. Every replica derives the same kid from
+// the same key without having to configure one.
+func thumbprint(members string) string {
+	sum := sha256.Sum256([]byte(members))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (k *jwtKeys) sign(claims jwt.MapClaims) (string, error) {
+	claims["iss"] = k.issuer
+	claims["aud"] = k.audience
+	token := jwt.NewWithClaims(k.method, claims)
+	if k.kid != "" {
+		token.Header["kid"] = k.kid
+	}
+	return token.SignedString(k.signingKey)
+}
+
+// keyFunc picks the key by the kid header and only accepts the algorithm
+// that key is used with.
+func (k *jwtKeys) keyFunc(token *jwt.Token) (any, error) {
+	if len(k.keys) == 0 {
+		return k.signingKey, nil
+	}
+	kid, _ := token.Header["kid"].(string)
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, errors.New("unknown signing key")
+	}
+	if token.Method.Alg() != key.jwk.Alg {
+		return nil, errors.New("signing method doesn't match the key")
+	}
+	return key.key, nil
+}
+
+func (k *jwtKeys) parse(tokenString string) (*jwt.Token, error) {
+	token, err := jwt.Parse(tokenString, k.keyFunc,
+		jwt.WithValidMethods(k.methods),
+		jwt.WithIssuer(k.issuer),
+		jwt.WithAudience(k.audience),
+		jwt.WithIssuedAt(),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	issuedAt, err := token.Claims.GetIssuedAt()
+	if err != nil {
+		return nil, err
+	}
+	if issuedAt == nil {
+		return nil, errors.New("token has no issued at claim")
+	}
+	return token, nil
+}
+
+func (na *NotifyApp) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, http.StatusOK, na.jwtKeys.jwks)
+}
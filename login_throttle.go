@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"birthday/db"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	errInvalidCredentials     = errors.New("invalid email or password")
+	errLoginThrottled         = errors.New("too many failed login attempts, try again later")
+	errPasswordResetThrottled = errors.New("too many password reset attempts, try again later")
+	errVerificationThrottled  = errors.New("too many verification email requests, try again later")
+)
+
+// dummyPasswordHash is compared against when nobody has the email, so that
+// unknown emails take as long to reject as wrong passwords.
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, err := bcrypt.GenerateFromPassword([]byte("dummy password"), db.PasswordHashCost)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
+// clientIP returns the address logins are throttled by. Behind a reverse
+// proxy that is the right-most X-Forwarded-For entry, the one the proxy
+// appended, anything before it is up to the client.
+func (na *NotifyApp) clientIP(r *http.Request) string {
+	if na.trustXForwardedFor {
+		if forwardedFor := r.Header.Values("X-Forwarded-For"); len(forwardedFor) > 0 {
+			addresses := strings.Split(forwardedFor[len(forwardedFor)-1], ",")
+			if ip := strings.TrimSpace(addresses[len(addresses)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// loginBlocked responds with 429 and returns true while logins to the email
+// or from the client's address are delayed or locked out.
+func (na *NotifyApp) loginBlocked(w http.ResponseWriter, email, ip string) bool {
+	blockedUntil, err := na.dbConnection.LoginBlockedUntil(email, ip)
+	return respondIfBlocked(w, blockedUntil, err, errLoginThrottled)
+}
+
+// passwordResetBlocked is loginBlocked for password reset requests and
+// resets from the client's address.
+func (na *NotifyApp) passwordResetBlocked(w http.ResponseWriter, ip string) bool {
+	blockedUntil, err := na.dbConnection.PasswordResetBlockedUntil(ip)
+	return respondIfBlocked(w, blockedUntil, err, errPasswordResetThrottled)
+}
+
+// verificationEmailBlocked is loginBlocked for requests for a new email
+// verification token from the client's address.
+func (na *NotifyApp) verificationEmailBlocked(w http.ResponseWriter, ip string) bool {
+	blockedUntil, err := na.dbConnection.VerificationEmailBlockedUntil(ip)
+	return respondIfBlocked(w, blockedUntil, err, errVerificationThrottled)
+}
+
+func respondIfBlocked(w http.ResponseWriter, blockedUntil time.Time, err, errThrottled error) bool {
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err)
+		return true
+	}
+	wait := time.Until(blockedUntil)
+	if wait <= 0 {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	respondWithError(w, http.StatusTooManyRequests, errThrottled)
+	return true
+}
@@ -1,14 +1,22 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	_ "time/tzdata"
 
+	"birthday/dates"
 	"birthday/db"
+	"birthday/notify"
 	"birthday/types"
 
 	"github.com/gorilla/mux"
+	"github.com/joho/godotenv"
 	"github.com/mvrilo/go-redoc"
 
 	_ "birthday/docs"
@@ -16,36 +24,116 @@ import (
 
 const (
 	APP_PORT                   string = ":8000"
+	DB_DRIVER_ENV              string = "DB_DRIVER"
 	DB_HOST_ENV                string = "DB_HOST"
 	DB_USER_ENV                string = "POSTGRES_USER"
 	DB_PASS_ENV                string = "POSTGRES_PASSWORD"
 	DB_NAME_ENV                string = "POSTGRES_DB"
 	DB_PORT_ENV                string = "DB_PORT"
+	SQLITE_PATH_ENV            string = "SQLITE_PATH"
+	LEAP_DAY_POLICY_ENV        string = "LEAP_DAY_POLICY"
 	DB_CONNECTION_URL_TEMPLATE string = "host=%s user=%s password=%s dbname=%s port=%s sslmode=disable"
 	ADMIN_USER_FIRST_NAME             = "ADMIN_USER_FIRST_NAME"
 	ADMIN_USER_LAST_NAME              = "ADMIN_USER_LAST_NAME"
 	ADMIN_USER_EMAIL                  = "ADMIN_USER_EMAIL"
 	ADMIN_USER_BIRTHDAY               = "ADMIN_USER_BIRTHDAY"
 	ADMIN_USER_PASSWORD               = "ADMIN_USER_PASSWORD"
+	NOTIFY_TIME_ENV            string = "NOTIFY_TIME"
+	NOTIFIER_ENV               string = "NOTIFIER"
+	SMTP_HOST_ENV              string = "SMTP_HOST"
+	SMTP_PORT_ENV              string = "SMTP_PORT"
+	SMTP_USER_ENV              string = "SMTP_USER"
+	SMTP_PASS_ENV              string = "SMTP_PASSWORD"
+	SMTP_FROM_ENV              string = "SMTP_FROM"
+	SMTP_STARTTLS_ENV          string = "SMTP_STARTTLS"
+	MAILER_ENV                 string = "MAILER"
+	MAILER_DIR_ENV             string = "MAILER_DIR"
+	DEFAULT_MAILER_DIR         string = "mail"
+	PASSWORD_RESET_URL_ENV     string = "PASSWORD_RESET_URL"
+	EMAIL_VERIFICATION_URL_ENV string = "EMAIL_VERIFICATION_URL"
+	REQUIRE_VERIFIED_EMAIL_ENV string = "REQUIRE_VERIFIED_EMAIL"
+	TRUST_X_FORWARDED_FOR_ENV  string = "TRUST_X_FORWARDED_FOR"
+	JWT_SECRET_KEY_ENV         string = "JWT_SECRET_KEY"
+	JWT_SIGNING_KEY_ENV        string = "JWT_SIGNING_KEY"
+	JWT_VERIFICATION_KEYS_ENV  string = "JWT_VERIFICATION_KEYS"
+	JWT_ISSUER_ENV             string = "JWT_ISSUER"
+	JWT_AUDIENCE_ENV           string = "JWT_AUDIENCE"
+	DEFAULT_JWT_ISSUER         string = "birthday_notify"
 )
 
 type NotifyApp struct {
-	Router       *mux.Router
-	dbConnection db.DataBase
+	Router        *mux.Router
+	dbConnection  db.Store
+	scheduler     *Scheduler
+	mailer        notify.Mailer
+	jwtKeys       *jwtKeys
+	leapDayPolicy dates.LeapDayPolicy
+	// requireVerifiedEmail refuses logins to users who haven't verified
+	// their email.
+	requireVerifiedEmail bool
+	// trustXForwardedFor takes the client address used to throttle logins
+	// from the X-Forwarded-For header set by a reverse proxy.
+	trustXForwardedFor bool
 }
 
 func (na *NotifyApp) Run(port string) {
+	na.scheduler.Start()
 	log.Fatal(http.ListenAndServe(port, na.Router))
 }
 
 func Initialize() (NotifyApp, error) {
 	var na NotifyApp
-	var err error
-	na.dbConnection, err = db.ConnectToDb(DB_HOST_ENV, DB_USER_ENV, DB_PASS_ENV, DB_NAME_ENV, DB_PORT_ENV, DB_CONNECTION_URL_TEMPLATE)
+	// Everything below reads its settings from the environment, which the
+	// .env file is part of.
+	err := godotenv.Load()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return NotifyApp{}, fmt.Errorf("error loading .env file: %w", err)
+	}
+	na.jwtKeys, err = newJWTKeys()
+	if err != nil {
+		return NotifyApp{}, err
+	}
+	database, err := db.ConnectToDb(DB_DRIVER_ENV, DB_HOST_ENV, DB_USER_ENV, DB_PASS_ENV, DB_NAME_ENV, DB_PORT_ENV, SQLITE_PATH_ENV, DB_CONNECTION_URL_TEMPLATE)
 	if err != nil {
 		return NotifyApp{}, fmt.Errorf("failed to connect to a database: %w", err)
 	}
-	err = na.dbConnection.DB.AutoMigrate(&types.BirthdayUser{})
+	database.LeapDayPolicy, err = dates.ParseLeapDayPolicy(os.Getenv(LEAP_DAY_POLICY_ENV))
+	if err != nil {
+		return NotifyApp{}, err
+	}
+	if requireVerifiedEmail := os.Getenv(REQUIRE_VERIFIED_EMAIL_ENV); requireVerifiedEmail != "" {
+		database.RequireVerifiedEmail, err = strconv.ParseBool(requireVerifiedEmail)
+		if err != nil {
+			return NotifyApp{}, fmt.Errorf("invalid %s: %w", REQUIRE_VERIFIED_EMAIL_ENV, err)
+		}
+	}
+	if trustXForwardedFor := os.Getenv(TRUST_X_FORWARDED_FOR_ENV); trustXForwardedFor != "" {
+		na.trustXForwardedFor, err = strconv.ParseBool(trustXForwardedFor)
+		if err != nil {
+			return NotifyApp{}, fmt.Errorf("invalid %s: %w", TRUST_X_FORWARDED_FOR_ENV, err)
+		}
+	}
+	na.dbConnection = database
+	na.leapDayPolicy = database.LeapDayPolicy
+	na.requireVerifiedEmail = database.RequireVerifiedEmail
+	err = na.dbConnection.Migrate()
+	if err != nil {
+		return NotifyApp{}, err
+	}
+	err = na.createAdminUser()
+	if err != nil {
+		return NotifyApp{}, fmt.Errorf("failed to create admin user: %w", err)
+	}
+	notifier, err := newNotifier(os.Getenv(NOTIFIER_ENV))
+	if err != nil {
+		return NotifyApp{}, err
+	}
+	notifier = notify.MultiNotifier{notifier, notify.NewWebhookNotifier(na.dbConnection)}
+	na.scheduler, err = NewScheduler(na.dbConnection, notifier, os.Getenv(NOTIFY_TIME_ENV))
+	if err != nil {
+		return NotifyApp{}, err
+	}
+	na.mailer, err = newMailer(os.Getenv(MAILER_ENV))
 	if err != nil {
 		return NotifyApp{}, err
 	}
@@ -61,17 +149,100 @@ func Initialize() (NotifyApp, error) {
 	return na, nil
 }
 
+func newNotifier(kind string) (notify.Notifier, error) {
+	switch kind {
+	case "", "log":
+		return notify.LogNotifier{}, nil
+	case "smtp":
+		return notify.NewSMTPNotifier(smtpConfigFromEnv())
+	default:
+		return nil, fmt.Errorf("unknown notifier %q", kind)
+	}
+}
+
+func newMailer(kind string) (notify.Mailer, error) {
+	switch kind {
+	case "", "log":
+		return notify.LogMailer{}, nil
+	case "file":
+		dir := os.Getenv(MAILER_DIR_ENV)
+		if dir == "" {
+			dir = DEFAULT_MAILER_DIR
+		}
+		return notify.FileMailer{Dir: dir}, nil
+	case "smtp":
+		return notify.NewSMTPNotifier(smtpConfigFromEnv())
+	default:
+		return nil, fmt.Errorf("unknown mailer %q", kind)
+	}
+}
+
+func smtpConfigFromEnv() notify.SMTPConfig {
+	startTLS, err := strconv.ParseBool(os.Getenv(SMTP_STARTTLS_ENV))
+	if err != nil {
+		startTLS = true
+	}
+	return notify.SMTPConfig{
+		Host:     os.Getenv(SMTP_HOST_ENV),
+		Port:     os.Getenv(SMTP_PORT_ENV),
+		Username: os.Getenv(SMTP_USER_ENV),
+		Password: os.Getenv(SMTP_PASS_ENV),
+		From:     os.Getenv(SMTP_FROM_ENV),
+		StartTLS: startTLS,
+	}
+}
+
 func (na *NotifyApp) setupRoutes() {
 	na.Router.HandleFunc("/api/users", na.getUsersHandler).Methods("GET")
 	na.Router.HandleFunc("/api/users", na.createUsersHandler).Methods("POST")
-	na.Router.Handle("/api/users/{id:[0-9]+}", authorizationRequired(http.HandlerFunc(na.getUserHandler))).Methods("PUT", "PATCH")
+	na.Router.Handle("/api/users/export", na.authorizationRequired(http.HandlerFunc(na.exportUsersHandler))).Methods("GET")
+	na.Router.Handle("/api/users/import", na.roleRequired(types.RoleAdmin, http.HandlerFunc(na.importUsersHandler))).Methods("POST")
+	na.Router.Handle("/api/users/{id:[0-9]+}", na.authorizationRequired(http.HandlerFunc(na.getUserHandler))).Methods("PUT", "PATCH")
 	na.Router.HandleFunc("/api/users/{id:[0-9]+}", na.getUserHandler).Methods("GET")
-	na.Router.Handle("/api/users/{id:[0-9]+}/subscribe", authorizationRequired(http.HandlerFunc(na.subscribeToUserHandler))).Methods("POST")
-	na.Router.Handle("/api/users/{id:[0-9]+}/unsubscribe", authorizationRequired(http.HandlerFunc(na.unsubscribeFromUserHandler))).Methods("POST")
-	na.Router.Handle("/api/birthdays", authorizationRequired(http.HandlerFunc(na.getBirthdaysHandler))).Methods("GET")
-	na.Router.Handle("/api/subscriptions", authorizationRequired(http.HandlerFunc(na.getSubscriptionsHandler))).Methods("GET")
+	na.Router.Handle("/api/users/{id:[0-9]+}", na.authorizationRequired(http.HandlerFunc(na.deleteUserHandler))).Methods("DELETE")
+	na.Router.Handle("/api/users/me/export", na.authorizationRequired(http.HandlerFunc(na.exportUserDataHandler))).Methods("GET")
+	na.Router.Handle("/api/users/me/privacy", na.authorizationRequired(http.HandlerFunc(na.privacySettingsHandler))).Methods("GET", "PUT", "PATCH")
+	na.Router.Handle("/api/users/me/delivery-preferences", na.authorizationRequired(http.HandlerFunc(na.deliveryPreferencesHandler))).Methods("GET", "PUT", "PATCH")
+	na.Router.Handle("/api/users/{id:[0-9]+}/subscribe", na.authorizationRequired(http.HandlerFunc(na.subscribeToUserHandler))).Methods("POST")
+	na.Router.Handle("/api/users/{id:[0-9]+}/unsubscribe", na.authorizationRequired(http.HandlerFunc(na.unsubscribeFromUserHandler))).Methods("POST")
+	na.Router.Handle("/api/birthdays", na.authorizationRequired(http.HandlerFunc(na.getBirthdaysHandler))).Methods("GET")
+	na.Router.Handle("/api/birthdays/upcoming", na.authorizationRequired(http.HandlerFunc(na.getUpcomingBirthdaysHandler))).Methods("GET")
+	na.Router.Handle("/api/subscriptions", na.authorizationRequired(http.HandlerFunc(na.getSubscriptionsHandler))).Methods("GET")
+	na.Router.Handle("/api/subscriptions/{id:[0-9]+}", na.authorizationRequired(http.HandlerFunc(na.subscriptionHandler))).Methods("GET", "PATCH")
+	na.Router.Handle("/api/subscriptions/export", na.authorizationRequired(http.HandlerFunc(na.exportSubscriptionsHandler))).Methods("GET")
+	na.Router.HandleFunc("/api/subscriptions/calendar.ics", na.calendarFeedHandler).Methods("GET")
+	na.Router.Handle("/api/subscriptions/calendar/token", na.authorizationRequired(http.HandlerFunc(na.createCalendarTokenHandler))).Methods("POST")
+	na.Router.Handle("/api/subscription-requests", na.authorizationRequired(http.HandlerFunc(na.getSubscriptionRequestsHandler))).Methods("GET")
+	na.Router.Handle("/api/subscription-requests/{id:[0-9]+}/approve", na.authorizationRequired(http.HandlerFunc(na.approveSubscriptionRequestHandler))).Methods("POST")
+	na.Router.Handle("/api/subscription-requests/{id:[0-9]+}/reject", na.authorizationRequired(http.HandlerFunc(na.rejectSubscriptionRequestHandler))).Methods("POST")
+	na.Router.Handle("/api/subscriptions/groups", na.authorizationRequired(http.HandlerFunc(na.getSubscribedGroupsHandler))).Methods("GET")
+	na.Router.Handle("/api/groups", na.authorizationRequired(http.HandlerFunc(na.getGroupsHandler))).Methods("GET")
+	na.Router.Handle("/api/groups", na.authorizationRequired(http.HandlerFunc(na.createGroupHandler))).Methods("POST")
+	na.Router.Handle("/api/groups/{id:[0-9]+}", na.authorizationRequired(http.HandlerFunc(na.groupHandler))).Methods("GET", "DELETE")
+	na.Router.Handle("/api/groups/{id:[0-9]+}/members", na.authorizationRequired(http.HandlerFunc(na.setGroupMemberHandler))).Methods("POST")
+	na.Router.Handle("/api/groups/{id:[0-9]+}/members/{userId:[0-9]+}", na.authorizationRequired(http.HandlerFunc(na.removeGroupMemberHandler))).Methods("DELETE")
+	na.Router.Handle("/api/groups/{id:[0-9]+}/subscribe", na.authorizationRequired(http.HandlerFunc(na.subscribeToGroupHandler))).Methods("POST")
+	na.Router.Handle("/api/groups/{id:[0-9]+}/unsubscribe", na.authorizationRequired(http.HandlerFunc(na.unsubscribeFromGroupHandler))).Methods("POST")
+	na.Router.Handle("/api/webhooks", na.authorizationRequired(http.HandlerFunc(na.getWebhooksHandler))).Methods("GET")
+	na.Router.Handle("/api/webhooks", na.authorizationRequired(http.HandlerFunc(na.createWebhookHandler))).Methods("POST")
+	na.Router.Handle("/api/webhooks/{id:[0-9]+}", na.authorizationRequired(http.HandlerFunc(na.webhookHandler))).Methods("GET", "PUT", "PATCH", "DELETE")
+	na.Router.Handle("/api/webhooks/{id:[0-9]+}/deliveries", na.authorizationRequired(http.HandlerFunc(na.getWebhookDeliveriesHandler))).Methods("GET")
+	na.Router.Handle("/api/admin/users/{id:[0-9]+}", na.roleRequired(types.RoleAdmin, http.HandlerFunc(na.adminUserHandler))).Methods("PUT", "PATCH", "DELETE")
+	na.Router.Handle("/api/admin/users/{id:[0-9]+}/unlock", na.roleRequired(types.RoleAdmin, http.HandlerFunc(na.adminUnlockUserHandler))).Methods("POST")
+	na.Router.Handle("/api/admin/users/{id:[0-9]+}/subscriptions", na.roleRequired(types.RoleAdmin, http.HandlerFunc(na.adminGetSubscriptionsHandler))).Methods("GET")
+	na.Router.Handle("/api/admin/users/{id:[0-9]+}/subscriptions/{subscriptionId:[0-9]+}", na.roleRequired(types.RoleAdmin, http.HandlerFunc(na.adminSubscriptionHandler))).Methods("POST", "DELETE")
 	na.Router.HandleFunc("/api/auth/token", na.getTokenhandler).Methods("POST")
+	na.Router.HandleFunc("/api/auth/refresh", na.refreshTokenHandler).Methods("POST")
+	na.Router.Handle("/api/auth/logout", na.authorizationRequired(http.HandlerFunc(na.logoutHandler))).Methods("POST")
+	na.Router.Handle("/api/auth/keys", na.authorizationRequired(http.HandlerFunc(na.getAPIKeysHandler))).Methods("GET")
+	na.Router.Handle("/api/auth/keys", na.authorizationRequired(http.HandlerFunc(na.createAPIKeyHandler))).Methods("POST")
+	na.Router.Handle("/api/auth/keys/{id:[0-9]+}", na.authorizationRequired(http.HandlerFunc(na.revokeAPIKeyHandler))).Methods("DELETE")
+	na.Router.HandleFunc("/api/auth/password/forgot", na.forgotPasswordHandler).Methods("POST")
+	na.Router.HandleFunc("/api/auth/password/reset", na.resetPasswordHandler).Methods("POST")
+	na.Router.HandleFunc("/api/auth/email/verify", na.verifyEmailHandler).Methods("POST")
+	na.Router.HandleFunc("/api/auth/email/resend", na.resendEmailVerificationHandler).Methods("POST")
 	na.Router.HandleFunc("/api/liveness", livenessCheckHandler).Methods("GET")
+	na.Router.HandleFunc("/.well-known/jwks.json", na.jwksHandler).Methods("GET")
 }
 
 func main() {
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestInitializeLoadsEnvFile sets the JWT secret in .env only, which has to
+// be loaded before the keys are built.
+func TestInitializeLoadsEnvFile(t *testing.T) {
+	for _, key := range []string{JWT_SECRET_KEY_ENV, JWT_SIGNING_KEY_ENV, DB_DRIVER_ENV} {
+		t.Setenv(key, "")
+		os.Unsetenv(key)
+	}
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, ".env"), []byte(JWT_SECRET_KEY_ENV+"=env file secret\n"+DB_DRIVER_ENV+"=memory\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Initialize also serves the API spec from the working directory.
+	spec, err := os.ReadFile("docs/swagger.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Mkdir(filepath.Join(dir, "docs"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "docs", "swagger.json"), spec, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	na, err := Initialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(na.jwtKeys.signingKey.([]byte)) != "env file secret" {
+		t.Error("the JWT keys don't use the secret from .env")
+	}
+}
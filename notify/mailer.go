@@ -0,0 +1,48 @@
+package notify
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const fileMailerSender = "birthday@localhost"
+
+// Mailer sends a single email with a plain text and an HTML body. Besides
+// SMTPNotifier, LogMailer and FileMailer implement it for local runs.
+type Mailer interface {
+	Send(to, subject, text, html string) error
+}
+
+var _ Mailer = (*SMTPNotifier)(nil)
+
+// LogMailer writes the recipient and the subject of emails to the standard
+// logger instead of sending them. The body is left out: it carries password
+// reset and email verification tokens, which anyone reading the logs could
+// use. FileMailer keeps whole emails for local runs.
+type LogMailer struct{}
+
+func (LogMailer) Send(to, subject, text, html string) error {
+	log.Printf("email to %s, subject %q, not sent\n", to, subject)
+	return nil
+}
+
+// FileMailer writes every email as an .eml file into Dir, where any mail
+// client can open it.
+type FileMailer struct {
+	Dir string
+}
+
+func (m FileMailer) Send(to, subject, text, html string) error {
+	message, err := buildMessage(fileMailerSender, "localhost", to, subject, text, html)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(m.Dir, 0o755)
+	if err != nil {
+		return err
+	}
+	name := time.Now().Format("20060102-150405.000000000") + ".eml"
+	return os.WriteFile(filepath.Join(m.Dir, name), message, 0o600)
+}
@@ -0,0 +1,26 @@
+package notify
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLogMailerLeavesOutTheBody(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	err := LogMailer{}.Send("ann@example.com", "Reset your password", "The token is:\n\nsecret-token\n", "<code>secret-token</code>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(logs.String(), "secret-token") {
+		t.Errorf("logged the token: %q", logs.String())
+	}
+	if !strings.Contains(logs.String(), "ann@example.com") || !strings.Contains(logs.String(), "Reset your password") {
+		t.Errorf("logged %q, want the recipient and the subject", logs.String())
+	}
+}
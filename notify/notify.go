@@ -0,0 +1,81 @@
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"birthday/types"
+)
+
+// Notifier delivers the list of birthdays due a reminder today, or a digest
+// of the birthdays of a period, to a single subscriber.
+type Notifier interface {
+	Notify(subscriber types.BirthdayUserResponse, birthdays []types.UpcomingBirthday) error
+	NotifyDigest(subscriber types.BirthdayUserResponse, digest types.Digest) error
+}
+
+// LogNotifier writes notifications to the standard logger. It is used when
+// no other delivery channel is configured.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(subscriber types.BirthdayUserResponse, birthdays []types.UpcomingBirthday) error {
+	log.Printf("notifying %s about birthdays of %s\n", subscriber.Email, strings.Join(Reminders(birthdays), ", "))
+	return nil
+}
+
+func (LogNotifier) NotifyDigest(subscriber types.BirthdayUserResponse, digest types.Digest) error {
+	birthdays := make([]string, 0, len(digest.Birthdays))
+	for _, birthday := range digest.Birthdays {
+		birthdays = append(birthdays, fmt.Sprintf("%s %s on %s", birthday.FirstName, birthday.LastName, birthday.NextBirthday))
+	}
+	log.Printf("sending %s digest to %s: %s\n", digest.Delivery, subscriber.Email, strings.Join(birthdays, ", "))
+	return nil
+}
+
+// Reminders describes each birthday as the full name followed by when it is,
+// like "Jane Doe today" or "John Doe in 7 days".
+func Reminders(birthdays []types.UpcomingBirthday) []string {
+	reminders := make([]string, 0, len(birthdays))
+	for _, birthday := range birthdays {
+		reminders = append(reminders, fmt.Sprintf("%s %s %s", birthday.FirstName, birthday.LastName, When(birthday.DaysUntil)))
+	}
+	return reminders
+}
+
+func When(daysUntil int) string {
+	switch daysUntil {
+	case 0:
+		return "today"
+	case 1:
+		return "tomorrow"
+	default:
+		return fmt.Sprintf("in %d days", daysUntil)
+	}
+}
+
+// MultiNotifier fans a notification out to several channels and reports the
+// combined error of the ones that failed. The others have delivered it by
+// then, so callers mustn't send the whole notification again.
+type MultiNotifier []Notifier
+
+func (m MultiNotifier) Notify(subscriber types.BirthdayUserResponse, birthdays []types.UpcomingBirthday) error {
+	var errs []error
+	for _, notifier := range m {
+		if err := notifier.Notify(subscriber, birthdays); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m MultiNotifier) NotifyDigest(subscriber types.BirthdayUserResponse, digest types.Digest) error {
+	var errs []error
+	for _, notifier := range m {
+		if err := notifier.NotifyDigest(subscriber, digest); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,283 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"birthday/types"
+)
+
+const (
+	defaultSMTPMaxAttempts = 3
+	defaultSMTPRetryDelay  = 5 * time.Second
+	smtpDialTimeout        = 30 * time.Second
+)
+
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+	StartTLS bool
+	// TLSConfig overrides the configuration used for STARTTLS. When nil the
+	// server certificate is verified against Host.
+	TLSConfig   *tls.Config
+	MaxAttempts int
+	RetryDelay  time.Duration
+}
+
+type SMTPNotifier struct {
+	config SMTPConfig
+	// from is the parsed config.From, which may include a display name that
+	// belongs in the From header but not in the envelope.
+	from *mail.Address
+}
+
+func NewSMTPNotifier(config SMTPConfig) (*SMTPNotifier, error) {
+	if config.Host == "" || config.Port == "" {
+		return nil, errors.New("smtp host and port are required")
+	}
+	if config.From == "" {
+		config.From = config.Username
+	}
+	from, err := mail.ParseAddress(config.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid smtp sender address %q: %w", config.From, err)
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = defaultSMTPMaxAttempts
+	}
+	if config.RetryDelay <= 0 {
+		config.RetryDelay = defaultSMTPRetryDelay
+	}
+	return &SMTPNotifier{config: config, from: from}, nil
+}
+
+var textBody = texttemplate.Must(texttemplate.New("text").Funcs(texttemplate.FuncMap{"when": When}).Parse(`Hi {{.Subscriber.FirstName}}!
+
+Birthdays coming up:
+{{range .Birthdays}}- {{.FirstName}} {{.LastName}}{{if .Email}} ({{.Email}}){{end}}, {{when .DaysUntil}}{{if .DaysUntil}} on {{.NextBirthday}}{{end}}
+{{end}}
+Don't forget to congratulate them!
+`))
+
+var htmlBody = htmltemplate.Must(htmltemplate.New("html").Funcs(htmltemplate.FuncMap{"when": When}).Parse(`<!DOCTYPE html>
+<html>
+<body>
+<p>Hi {{.Subscriber.FirstName}}!</p>
+<p>Birthdays coming up:</p>
+<ul>
+{{range .Birthdays}}<li>{{.FirstName}} {{.LastName}}{{if .Email}} (<a href="mailto:{{.Email}}">{{.Email}}</a>){{end}}, {{when .DaysUntil}}{{if .DaysUntil}} on {{.NextBirthday}}{{end}}</li>
+{{end}}</ul>
+<p>Don't forget to congratulate them!</p>
+</body>
+</html>
+`))
+
+func (n *SMTPNotifier) Notify(subscriber types.BirthdayUserResponse, birthdays []types.UpcomingBirthday) error {
+	data := struct {
+		Subscriber types.BirthdayUserResponse
+		Birthdays  []types.UpcomingBirthday
+	}{subscriber, birthdays}
+
+	var text, html bytes.Buffer
+	if err := textBody.Execute(&text, data); err != nil {
+		return err
+	}
+	if err := htmlBody.Execute(&html, data); err != nil {
+		return err
+	}
+	subject := "Birthdays: " + strings.Join(Reminders(birthdays), ", ")
+	return n.Send(subscriber.Email, subject, text.String(), html.String())
+}
+
+var digestTextBody = texttemplate.Must(texttemplate.New("digestText").Parse(`Hi {{.Subscriber.FirstName}}!
+
+Upcoming birthdays from {{.Digest.From}} to {{.Digest.To}}:
+{{range .Digest.Birthdays}}- {{.NextBirthday}}: {{.FirstName}} {{.LastName}}{{if not .BirthYearHidden}}, turning {{.TurningAge}}{{end}}{{if .Email}} ({{.Email}}){{end}}
+{{end}}
+Don't forget to congratulate them!
+`))
+
+var digestHTMLBody = htmltemplate.Must(htmltemplate.New("digestHTML").Parse(`<!DOCTYPE html>
+<html>
+<body>
+<p>Hi {{.Subscriber.FirstName}}!</p>
+<p>Upcoming birthdays from {{.Digest.From}} to {{.Digest.To}}:</p>
+<ul>
+{{range .Digest.Birthdays}}<li>{{.NextBirthday}}: {{.FirstName}} {{.LastName}}{{if not .BirthYearHidden}}, turning {{.TurningAge}}{{end}}{{if .Email}} (<a href="mailto:{{.Email}}">{{.Email}}</a>){{end}}</li>
+{{end}}</ul>
+<p>Don't forget to congratulate them!</p>
+</body>
+</html>
+`))
+
+func (n *SMTPNotifier) NotifyDigest(subscriber types.BirthdayUserResponse, digest types.Digest) error {
+	data := struct {
+		Subscriber types.BirthdayUserResponse
+		Digest     types.Digest
+	}{subscriber, digest}
+
+	var text, html bytes.Buffer
+	if err := digestTextBody.Execute(&text, data); err != nil {
+		return err
+	}
+	if err := digestHTMLBody.Execute(&html, data); err != nil {
+		return err
+	}
+	subject := fmt.Sprintf("Your %s birthday digest: %d upcoming", digest.Delivery, len(digest.Birthdays))
+	return n.Send(subscriber.Email, subject, text.String(), html.String())
+}
+
+// Send delivers a multipart text+HTML message, retrying transient failures.
+func (n *SMTPNotifier) Send(to, subject, text, html string) error {
+	message, err := buildMessage(n.from.String(), n.config.Host, to, subject, text, html)
+	if err != nil {
+		return err
+	}
+
+	delay := n.config.RetryDelay
+	for attempt := 1; ; attempt++ {
+		err = n.deliver(to, message)
+		if err == nil {
+			return nil
+		}
+		if attempt >= n.config.MaxAttempts || !isTransientSMTPError(err) {
+			return fmt.Errorf("sending email to %s: %w", to, err)
+		}
+		log.Printf("smtp: attempt %d to send email to %s failed, retrying in %s: %v\n", attempt, to, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func buildMessage(from, host, to, subject, text, html string) ([]byte, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, part := range []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=UTF-8", text},
+		{"text/html; charset=UTF-8", html},
+	} {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", part.contentType)
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+		w, err := writer.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err = io.WriteString(qp, part.content); err != nil {
+			return nil, err
+		}
+		if err = qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	var message bytes.Buffer
+	headers := []struct{ key, value string }{
+		{"From", from},
+		{"To", to},
+		{"Subject", mime.QEncoding.Encode("UTF-8", subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", messageID(host)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + writer.Boundary()},
+	}
+	for _, h := range headers {
+		fmt.Fprintf(&message, "%s: %s\r\n", h.key, h.value)
+	}
+	message.WriteString("\r\n")
+	message.Write(body.Bytes())
+	return message.Bytes(), nil
+}
+
+func (n *SMTPNotifier) deliver(to string, message []byte) error {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(n.config.Host, n.config.Port), smtpDialTimeout)
+	if err != nil {
+		return err
+	}
+	client, err := smtp.NewClient(conn, n.config.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if n.config.StartTLS {
+		tlsConfig := n.config.TLSConfig
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{ServerName: n.config.Host}
+		}
+		if err = client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if n.config.Username != "" {
+		auth := smtp.PlainAuth("", n.config.Username, n.config.Password, n.config.Host)
+		if err = client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err = client.Mail(n.from.Address); err != nil {
+		return err
+	}
+	if err = client.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(message); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// isTransientSMTPError reports whether a failed delivery is worth retrying:
+// network failures and 4xx replies are, permanent 5xx replies are not.
+func isTransientSMTPError(err error) bool {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return protoErr.Code >= 400 && protoErr.Code < 500
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func messageID(host string) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(b), host)
+}
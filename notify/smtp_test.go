@@ -0,0 +1,360 @@
+package notify
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"io"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"birthday/types"
+)
+
+// fakeSMTPSession is what the fake server saw during one connection.
+type fakeSMTPSession struct {
+	startTLS bool
+	auth     string
+	from     string
+	rcpt     string
+	data     string
+}
+
+// fakeSMTPServer speaks just enough SMTP for net/smtp. The n-th connection
+// answers RCPT TO with rcptReplies[n] when it is set.
+type fakeSMTPServer struct {
+	listener    net.Listener
+	tlsConfig   *tls.Config
+	rcptReplies []string
+
+	mu       sync.Mutex
+	sessions []*fakeSMTPSession
+}
+
+func newFakeSMTPServer(t *testing.T, tlsConfig *tls.Config, rcptReplies ...string) *fakeSMTPServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fakeSMTPServer{listener: listener, tlsConfig: tlsConfig, rcptReplies: rcptReplies}
+	t.Cleanup(func() { listener.Close() })
+	go server.serve()
+	return server
+}
+
+func (s *fakeSMTPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		session := &fakeSMTPSession{}
+		attempt := len(s.sessions)
+		s.sessions = append(s.sessions, session)
+		s.mu.Unlock()
+		go s.handle(conn, session, attempt)
+	}
+}
+
+func (s *fakeSMTPServer) handle(conn net.Conn, session *fakeSMTPSession, attempt int) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	reply := func(lines ...string) {
+		for _, line := range lines {
+			text.PrintfLine("%s", line)
+		}
+	}
+	record := func(update func()) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		update()
+	}
+
+	reply("220 fake ESMTP")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			if s.tlsConfig != nil && !session.startTLS {
+				reply("250-fake", "250-STARTTLS", "250 AUTH PLAIN")
+			} else {
+				reply("250-fake", "250 AUTH PLAIN")
+			}
+		case "STARTTLS":
+			reply("220 ready to start TLS")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			conn = tlsConn
+			text = textproto.NewConn(conn)
+			record(func() { session.startTLS = true })
+		case "AUTH":
+			_, encoded, _ := strings.Cut(arg, " ")
+			decoded, _ := base64.StdEncoding.DecodeString(encoded)
+			record(func() { session.auth = string(decoded) })
+			reply("235 authenticated")
+		case "MAIL":
+			record(func() { session.from = strings.TrimSuffix(strings.TrimPrefix(arg, "FROM:<"), ">") })
+			reply("250 ok")
+		case "RCPT":
+			if attempt < len(s.rcptReplies) && s.rcptReplies[attempt] != "" {
+				reply(s.rcptReplies[attempt])
+				continue
+			}
+			record(func() { session.rcpt = strings.TrimSuffix(strings.TrimPrefix(arg, "TO:<"), ">") })
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			data, err := io.ReadAll(text.DotReader())
+			if err != nil {
+				return
+			}
+			record(func() { session.data = string(data) })
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func (s *fakeSMTPServer) config() SMTPConfig {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return SMTPConfig{Host: host, Port: port, From: "Birthday Bot <bot@example.com>", RetryDelay: time.Millisecond}
+}
+
+func (s *fakeSMTPServer) attempts() []fakeSMTPSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sessions := make([]fakeSMTPSession, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, *session)
+	}
+	return sessions
+}
+
+func newTestSMTPNotifier(t *testing.T, config SMTPConfig) *SMTPNotifier {
+	t.Helper()
+	notifier, err := NewSMTPNotifier(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return notifier
+}
+
+// selfSignedTLS returns a server configuration with a certificate for
+// 127.0.0.1 and a client configuration that trusts it.
+func selfSignedTLS(t *testing.T) (*tls.Config, *tls.Config) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "fake smtp"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(certificate)
+	server := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	client := &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"}
+	return server, client
+}
+
+func TestSMTPNotifierSendsMultipartMessage(t *testing.T) {
+	server := newFakeSMTPServer(t, nil)
+	notifier := newTestSMTPNotifier(t, server.config())
+
+	subscriber := types.BirthdayUserResponse{BirthdayUserBase: types.BirthdayUserBase{FirstName: "Ann", Email: "ann@example.com"}}
+	birthdays := []types.UpcomingBirthday{{
+		BirthdayUserResponse: types.BirthdayUserResponse{BirthdayUserBase: types.BirthdayUserBase{FirstName: "Jane", LastName: "Doe", Email: "jane@example.com"}},
+		NextBirthday:         "2024-05-16",
+	}}
+	err := notifier.Notify(subscriber, birthdays)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attempts := server.attempts()
+	if len(attempts) != 1 {
+		t.Fatalf("got %d connections, want 1", len(attempts))
+	}
+	session := attempts[0]
+	if session.from != "bot@example.com" || session.rcpt != "ann@example.com" {
+		t.Errorf("envelope is %q -> %q, want bot@example.com -> ann@example.com", session.from, session.rcpt)
+	}
+
+	message, err := mail.ReadMessage(strings.NewReader(session.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	from, err := message.Header.AddressList("From")
+	if err != nil || len(from) != 1 || from[0].Name != "Birthday Bot" || from[0].Address != "bot@example.com" {
+		t.Errorf("From header is %q", message.Header.Get("From"))
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(message.Header.Get("Subject"))
+	if err != nil || subject != "Birthdays: Jane Doe today" {
+		t.Errorf("Subject is %q", subject)
+	}
+	mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type is %q", message.Header.Get("Content-Type"))
+	}
+
+	parts := map[string]string{}
+	reader := multipart.NewReader(message.Body, params["boundary"])
+	for {
+		part, err := reader.NextRawPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if encoding := part.Header.Get("Content-Transfer-Encoding"); encoding != "quoted-printable" {
+			t.Errorf("part is encoded as %q", encoding)
+		}
+		content, err := io.ReadAll(quotedprintable.NewReader(part))
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[part.Header.Get("Content-Type")] = string(content)
+	}
+	if len(parts) != 2 {
+		t.Fatalf("got parts %v, want text and HTML", parts)
+	}
+	text := parts["text/plain; charset=UTF-8"]
+	if !strings.Contains(text, "Hi Ann!") || !strings.Contains(text, "- Jane Doe (jane@example.com), today") {
+		t.Errorf("unexpected text part:\n%s", text)
+	}
+	html := parts["text/html; charset=UTF-8"]
+	if !strings.Contains(html, `<li>Jane Doe (<a href="mailto:jane@example.com">jane@example.com</a>), today</li>`) {
+		t.Errorf("unexpected HTML part:\n%s", html)
+	}
+}
+
+func TestSMTPNotifierStartTLSAndAuth(t *testing.T) {
+	serverTLS, clientTLS := selfSignedTLS(t)
+	server := newFakeSMTPServer(t, serverTLS)
+	config := server.config()
+	config.StartTLS = true
+	config.TLSConfig = clientTLS
+	config.Username = "bot"
+	config.Password = "secret"
+	notifier := newTestSMTPNotifier(t, config)
+
+	err := notifier.Send("ann@example.com", "Hello", "text", "<p>html</p>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := server.attempts()[0]
+	if !session.startTLS {
+		t.Error("connection was not upgraded with STARTTLS")
+	}
+	if session.auth != "\x00bot\x00secret" {
+		t.Errorf("got AUTH PLAIN %q", session.auth)
+	}
+	if session.data == "" {
+		t.Error("no message was sent")
+	}
+}
+
+func TestSMTPNotifierStartTLSRejectsUntrustedCertificate(t *testing.T) {
+	serverTLS, _ := selfSignedTLS(t)
+	server := newFakeSMTPServer(t, serverTLS)
+	config := server.config()
+	config.StartTLS = true
+	config.MaxAttempts = 1
+	notifier := newTestSMTPNotifier(t, config)
+
+	err := notifier.Send("ann@example.com", "Hello", "text", "<p>html</p>")
+	if err == nil {
+		t.Fatal("sent over TLS with an untrusted certificate")
+	}
+	if session := server.attempts()[0]; session.data != "" {
+		t.Error("message was sent despite the failed handshake")
+	}
+}
+
+func TestSMTPNotifierRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		rcptReplies  []string
+		wantAttempts int
+		wantCode     int
+	}{
+		{"succeeds first time", nil, 1, 0},
+		{"retries 4xx until success", []string{"451 try again later", "421 busy"}, 3, 0},
+		{"gives up after max attempts", []string{"451 try again later", "451 try again later", "451 try again later"}, 3, 451},
+		{"does not retry 5xx", []string{"550 no such user"}, 1, 550},
+		{"stops retrying at a 5xx", []string{"451 try again later", "554 rejected"}, 2, 554},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeSMTPServer(t, nil, tt.rcptReplies...)
+			notifier := newTestSMTPNotifier(t, server.config())
+
+			err := notifier.Send("ann@example.com", "Hello", "text", "<p>html</p>")
+			attempts := server.attempts()
+			if len(attempts) != tt.wantAttempts {
+				t.Errorf("got %d attempts, want %d", len(attempts), tt.wantAttempts)
+			}
+			if tt.wantCode == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if attempts[len(attempts)-1].data == "" {
+					t.Error("message was not sent on the last attempt")
+				}
+				return
+			}
+			var protoErr *textproto.Error
+			if !errors.As(err, &protoErr) || protoErr.Code != tt.wantCode {
+				t.Errorf("got error %v, want SMTP code %d", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestSMTPNotifierRejectsInvalidSender(t *testing.T) {
+	_, err := NewSMTPNotifier(SMTPConfig{Host: "localhost", Port: "25", From: "not an address"})
+	if err == nil {
+		t.Error("accepted an invalid sender address")
+	}
+}
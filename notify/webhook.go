@@ -0,0 +1,239 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+
+	"birthday/types"
+)
+
+const (
+	SignatureHeader = "X-Birthday-Signature"
+	EventHeader     = "X-Birthday-Event"
+	BirthdayEvent   = "birthday"
+	DigestEvent     = "digest"
+
+	defaultWebhookMaxAttempts  = 5
+	defaultWebhookRetryDelay   = time.Second
+	defaultWebhookDisableAfter = 5
+	webhookTimeout             = 10 * time.Second
+)
+
+// ErrPrivateAddress is the error of webhook URLs pointing to the loopback
+// interface or a private network, which would let users reach internal
+// services through the notifier.
+var ErrPrivateAddress = errors.New("webhook url must not point to a loopback or private address")
+
+type WebhookStore interface {
+	GetActiveWebhooks(userId int) ([]types.Webhook, error)
+	RecordWebhookDelivery(delivery types.WebhookDelivery) error
+	// MarkWebhookResult updates the consecutive failure counter of a webhook
+	// and disables it once the counter reaches disableAfter.
+	MarkWebhookResult(webhookId int, success bool, disableAfter int) (bool, error)
+}
+
+// WebhookNotifier posts a signed BirthdayEvent to every enabled webhook of
+// the subscriber, one request per birthday or reminder of one, and a single DigestEvent per
+// digest.
+type WebhookNotifier struct {
+	store  WebhookStore
+	client *http.Client
+	// deliveries counts the webhooks being called in the background.
+	deliveries   sync.WaitGroup
+	MaxAttempts  int
+	RetryDelay   time.Duration
+	DisableAfter int
+}
+
+func NewWebhookNotifier(store WebhookStore) *WebhookNotifier {
+	// The address is checked once resolved, so a host whose DNS changed
+	// since the webhook was saved can't reach internal services either.
+	// Requests don't go through a proxy for the same reason.
+	dialer := &net.Dialer{Timeout: webhookTimeout, Control: refusePrivateAddress}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &WebhookNotifier{
+		store:        store,
+		client:       &http.Client{Timeout: webhookTimeout, Transport: transport},
+		MaxAttempts:  defaultWebhookMaxAttempts,
+		RetryDelay:   defaultWebhookRetryDelay,
+		DisableAfter: defaultWebhookDisableAfter,
+	}
+}
+
+// Notify returns before the webhooks are called: each of them gets its
+// requests, retries included, from a goroutine of its own, so a slow endpoint
+// holds up neither the scheduler nor the other webhooks. It never fails
+// because of an unreachable endpoint either: failures end up in the delivery
+// log instead.
+func (n *WebhookNotifier) Notify(subscriber types.BirthdayUserResponse, birthdays []types.UpcomingBirthday) error {
+	webhooks, err := n.store.GetActiveWebhooks(subscriber.ID)
+	if err != nil {
+		return err
+	}
+	bodies := make([][]byte, 0, len(birthdays))
+	for _, birthday := range birthdays {
+		body, err := json.Marshal(types.BirthdayEvent{
+			Event:     BirthdayEvent,
+			Date:      birthday.NextBirthday,
+			DaysUntil: birthday.DaysUntil,
+			User:      birthday.BirthdayUserResponse,
+		})
+		if err != nil {
+			return err
+		}
+		bodies = append(bodies, body)
+	}
+	for _, webhook := range webhooks {
+		n.background(func() {
+			for i, body := range bodies {
+				if n.deliver(webhook, BirthdayEvent, birthdays[i].ID, body) {
+					break
+				}
+			}
+		})
+	}
+	return nil
+}
+
+// NotifyDigest delivers in the background and fails only like Notify does.
+// Its deliveries are logged with a zero birthday user id.
+func (n *WebhookNotifier) NotifyDigest(subscriber types.BirthdayUserResponse, digest types.Digest) error {
+	webhooks, err := n.store.GetActiveWebhooks(subscriber.ID)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(types.DigestEvent{Event: DigestEvent, Digest: digest})
+	if err != nil {
+		return err
+	}
+	for _, webhook := range webhooks {
+		n.background(func() { n.deliver(webhook, DigestEvent, 0, body) })
+	}
+	return nil
+}
+
+func (n *WebhookNotifier) background(deliver func()) {
+	n.deliveries.Add(1)
+	go func() {
+		defer n.deliveries.Done()
+		deliver()
+	}()
+}
+
+// Wait blocks until the deliveries started so far are over.
+func (n *WebhookNotifier) Wait() {
+	n.deliveries.Wait()
+}
+
+// deliver posts body to the webhook, retrying with a growing delay, and
+// reports whether the webhook got disabled because of the failures.
+func (n *WebhookNotifier) deliver(webhook types.Webhook, event string, birthdayUserId int, body []byte) bool {
+	success := n.attempt(webhook, event, birthdayUserId, body)
+	disabled, err := n.store.MarkWebhookResult(webhook.ID, success, n.DisableAfter)
+	if err != nil {
+		log.Printf("webhook: failed to update webhook %d: %v\n", webhook.ID, err)
+	}
+	if disabled {
+		log.Printf("webhook: webhook %d disabled after %d consecutive failures\n", webhook.ID, n.DisableAfter)
+	}
+	return disabled
+}
+
+func (n *WebhookNotifier) attempt(webhook types.Webhook, event string, birthdayUserId int, body []byte) bool {
+	delay := n.RetryDelay
+	for attempt := 1; attempt <= n.MaxAttempts; attempt++ {
+		statusCode, err := n.post(webhook, event, body)
+		delivery := types.WebhookDelivery{
+			WebhookID:      webhook.ID,
+			BirthdayUserID: birthdayUserId,
+			Attempt:        attempt,
+			StatusCode:     statusCode,
+			Success:        err == nil,
+		}
+		if err != nil {
+			delivery.Error = err.Error()
+		}
+		if recordErr := n.store.RecordWebhookDelivery(delivery); recordErr != nil {
+			log.Printf("webhook: failed to record delivery for webhook %d: %v\n", webhook.ID, recordErr)
+		}
+		if err == nil {
+			return true
+		}
+		if attempt < n.MaxAttempts {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+	return false
+}
+
+func (n *WebhookNotifier) post(webhook types.Webhook, event string, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, event)
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, body))
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// CheckWebhookHost fails with ErrPrivateAddress when host is, or resolves to,
+// an address webhooks mustn't be sent to.
+func CheckWebhookHost(host string) error {
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return fmt.Errorf("failed to resolve webhook host %s: %w", host, err)
+	}
+	for _, ip := range ips {
+		if privateAddress(ip) {
+			return ErrPrivateAddress
+		}
+	}
+	return nil
+}
+
+func refusePrivateAddress(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || privateAddress(ip) {
+		return ErrPrivateAddress
+	}
+	return nil
+}
+
+func privateAddress(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsMulticast()
+}
+
+// Sign returns the value of the signature header for body: the hex encoded
+// HMAC-SHA256 of the body keyed with the webhook secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,108 @@
+package notify
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"birthday/types"
+)
+
+// fakeWebhookStore keeps the delivery log in memory.
+type fakeWebhookStore struct {
+	webhooks []types.Webhook
+
+	mu         sync.Mutex
+	deliveries []types.WebhookDelivery
+}
+
+func (s *fakeWebhookStore) GetActiveWebhooks(userId int) ([]types.Webhook, error) {
+	return s.webhooks, nil
+}
+
+func (s *fakeWebhookStore) RecordWebhookDelivery(delivery types.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deliveries = append(s.deliveries, delivery)
+	return nil
+}
+
+func (s *fakeWebhookStore) MarkWebhookResult(webhookId int, success bool, disableAfter int) (bool, error) {
+	return false, nil
+}
+
+func TestWebhookDeliveryDoesNotBlock(t *testing.T) {
+	release := make(chan struct{})
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			<-release
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	store := &fakeWebhookStore{webhooks: []types.Webhook{{ID: 1, URL: server.URL, Secret: "secret"}}}
+	notifier := NewWebhookNotifier(store)
+	notifier.client = server.Client()
+	notifier.RetryDelay = time.Millisecond
+	birthdays := []types.UpcomingBirthday{{BirthdayUserResponse: types.BirthdayUserResponse{ID: 2}}}
+
+	done := make(chan error)
+	go func() { done <- notifier.Notify(types.BirthdayUserResponse{ID: 1}, birthdays) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Notify waited for the endpoint")
+	}
+	close(release)
+	notifier.Wait()
+
+	if len(store.deliveries) != 2 || store.deliveries[0].Success || !store.deliveries[1].Success {
+		t.Errorf("got deliveries %+v, want a failure and a successful retry", store.deliveries)
+	}
+}
+
+func TestWebhookRefusesPrivateAddresses(t *testing.T) {
+	var called bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer server.Close()
+
+	store := &fakeWebhookStore{webhooks: []types.Webhook{{ID: 1, URL: server.URL, Secret: "secret"}}}
+	notifier := NewWebhookNotifier(store)
+	notifier.MaxAttempts = 1
+	err := notifier.NotifyDigest(types.BirthdayUserResponse{ID: 1}, types.Digest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier.Wait()
+	if called {
+		t.Error("posted to a loopback address")
+	}
+	if len(store.deliveries) != 1 || !strings.Contains(store.deliveries[0].Error, ErrPrivateAddress.Error()) {
+		t.Errorf("got deliveries %+v, want one refused", store.deliveries)
+	}
+}
+
+func TestCheckWebhookHost(t *testing.T) {
+	for _, host := range []string{"127.0.0.1", "::1", "localhost", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "0.0.0.0", "fd00::1"} {
+		if err := CheckWebhookHost(host); !errors.Is(err, ErrPrivateAddress) {
+			t.Errorf("%s: got %v, want ErrPrivateAddress", host, err)
+		}
+	}
+	for _, host := range []string{"93.184.215.14", "2606:2800:21f:cb07:6820:80da:af6b:8b2c"} {
+		if err := CheckWebhookHost(host); err != nil {
+			t.Errorf("%s: got %v", host, err)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"birthday/db"
+	"birthday/types"
+
+	"gorm.io/gorm"
+)
+
+const passwordResetTokenTTL time.Duration = time.Hour
+
+// forgotPasswordHandler answers the same way whether the email belongs to an
+// account or not, and sends the email in the background so that the response
+// time doesn't tell either.
+func (na *NotifyApp) forgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var forgotRequest types.ForgotPasswordRequest
+	err := json.NewDecoder(r.Body).Decode(&forgotRequest)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer r.Body.Close()
+
+	if forgotRequest.Email == "" {
+		respondWithError(w, http.StatusBadRequest, errors.New("email field is required"))
+		return
+	}
+	ip := na.clientIP(r)
+	if na.passwordResetBlocked(w, ip) {
+		return
+	}
+	err = na.dbConnection.RecordPasswordResetAttempt(ip)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	go func() {
+		user, err := na.dbConnection.GetUserByEmail(forgotRequest.Email)
+		if err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				log.Printf("password reset: failed to look up user: %v\n", err)
+			}
+			return
+		}
+		err = na.sendPasswordReset(user)
+		if err != nil {
+			log.Printf("password reset: failed to send reset email to user %d: %v\n", user.ID, err)
+		}
+	}()
+
+	respondWithJSON(w, http.StatusAccepted, "if the email belongs to an account, a password reset token has been sent to it")
+}
+
+func (na *NotifyApp) sendPasswordReset(user types.BirthdayUser) error {
+	token, err := generateToken()
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(passwordResetTokenTTL)
+	err = na.dbConnection.CreatePasswordResetToken(user.ID, hashToken(token), expiresAt)
+	if err != nil {
+		return err
+	}
+
+	// The link comes from the configuration rather than the request, a
+	// forged Host header must not end up in the email.
+	instructions := "Send it together with a new password to POST /api/auth/password/reset."
+	if resetURL := os.Getenv(PASSWORD_RESET_URL_ENV); resetURL != "" {
+		instructions = "To choose a new password, open " + resetURL + "?token=" + url.QueryEscape(token)
+	}
+	text, body := tokenEmail(user.FirstName, "Somebody asked to reset the password of your account.", token, instructions, expiresAt)
+	return na.mailer.Send(user.Email, "Reset your password", text, body)
+}
+
+// tokenEmail returns the text and HTML bodies of an email carrying a
+// single-use token.
+func tokenEmail(name, reason, token, instructions string, expiresAt time.Time) (string, string) {
+	validUntil := expiresAt.UTC().Format(time.RFC1123)
+	text := fmt.Sprintf("Hi %s!\n\n%s The token is:\n\n%s\n\n%s\n\nThe token is valid until %s and works only once. If it wasn't you, just ignore this email.\n",
+		name, reason, token, instructions, validUntil)
+	body := fmt.Sprintf("<!DOCTYPE html>\n<html>\n<body>\n<p>Hi %s!</p>\n<p>%s The token is:</p>\n<p><code>%s</code></p>\n<p>%s</p>\n<p>The token is valid until %s and works only once. If it wasn't you, just ignore this email.</p>\n</body>\n</html>\n",
+		html.EscapeString(name), html.EscapeString(reason), token, html.EscapeString(instructions), validUntil)
+	return text, body
+}
+
+func (na *NotifyApp) resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var resetRequest types.ResetPasswordRequest
+	err := json.NewDecoder(r.Body).Decode(&resetRequest)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer r.Body.Close()
+
+	if resetRequest.Token == "" || resetRequest.Password == "" {
+		respondWithError(w, http.StatusBadRequest, errors.New("token and password fields are required"))
+		return
+	}
+
+	ip := na.clientIP(r)
+	if na.passwordResetBlocked(w, ip) {
+		return
+	}
+
+	err = na.dbConnection.ResetPassword(hashToken(resetRequest.Token), resetRequest.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrInvalidResetToken):
+			if err := na.dbConnection.RecordPasswordResetAttempt(ip); err != nil {
+				respondWithError(w, http.StatusInternalServerError, err)
+				return
+			}
+			respondWithError(w, http.StatusBadRequest, err)
+		default:
+			respondWithError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "password has been reset")
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"birthday/types"
+)
+
+func validatePrivacySettings(settings types.PrivacySettings) error {
+	switch settings.Visibility {
+	case types.VisibilityEveryone, types.VisibilitySubscribers, types.VisibilityNobody:
+		return nil
+	default:
+		return errors.New("visibility must be one of everyone, subscribers, nobody")
+	}
+}
+
+func (na *NotifyApp) privacySettingsHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := currentUserId(w, r)
+	if err != nil {
+		return
+	}
+	settings, err := na.dbConnection.GetPrivacySettings(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if r.Method == http.MethodGet {
+		respondWithJSON(w, http.StatusOK, settings)
+		return
+	}
+
+	// PATCH decodes over the current settings, PUT replaces all of them.
+	if r.Method == http.MethodPut {
+		settings = types.PrivacySettings{}
+	}
+	err = json.NewDecoder(r.Body).Decode(&settings)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer r.Body.Close()
+
+	err = validatePrivacySettings(settings)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	err = na.dbConnection.UpdatePrivacySettings(userId, settings)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, settings)
+}
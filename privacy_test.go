@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"birthday/types"
+)
+
+func (app *testApp) userIds(t *testing.T, path, token string) []int {
+	t.Helper()
+	w := app.request(t, http.MethodGet, path, token, nil)
+	expectStatus(t, w, http.StatusOK)
+	var ids []int
+	// Hidden birth years don't parse back into a time.Time.
+	for _, user := range decode[types.Page[struct {
+		ID int `json:"id"`
+	}]](t, w).Items {
+		ids = append(ids, user.ID)
+	}
+	return ids
+}
+
+func TestUserFiltersRespectPrivacy(t *testing.T) {
+	app := newTestApp(t)
+	ann := app.createUser(t, "ann@example.com", time.Date(1990, time.May, 16, 0, 0, 0, 0, time.UTC))
+	bob := app.createUser(t, "bob@hidden.org", time.Date(1985, time.December, 30, 0, 0, 0, 0, time.UTC))
+	bobToken := app.login(t, "bob@hidden.org")
+	w := app.request(t, http.MethodPut, "/api/users/me/privacy", bobToken, types.PrivacySettings{
+		HideBirthYear: true, HideEmail: true, Visibility: types.VisibilityEveryone,
+	})
+	expectStatus(t, w, http.StatusOK)
+	annToken := app.login(t, "ann@example.com")
+
+	tests := []struct {
+		query string
+		// want is what ann and bob see, bob sees everything of himself.
+		want, wantBob []int
+	}{
+		{"q=hidden", nil, []int{bob}},
+		{"q=ann", []int{ann}, []int{ann}},
+		// Bob's birthday in another year, which ann can't tell apart.
+		{"birthday_from=1999-12-01&birthday_to=2000-01-10", []int{bob}, nil},
+		{"birthday_from=1985-01-01&birthday_to=1985-06-30", nil, nil},
+		{"birthday_from=1985-12-01&birthday_to=1985-12-31", []int{bob}, []int{bob}},
+		{"birthday_from=1990-01-01", []int{ann, bob}, []int{ann}},
+		{"birthday_to=1980-01-01", []int{bob}, nil},
+	}
+	for _, tt := range tests {
+		if got := app.userIds(t, "/api/users?"+tt.query, annToken); !slices.Equal(got, tt.want) {
+			t.Errorf("ann: %s got %v, want %v", tt.query, got, tt.want)
+		}
+		if got := app.userIds(t, "/api/users?"+tt.query, bobToken); !slices.Equal(got, tt.wantBob) {
+			t.Errorf("bob: %s got %v, want %v", tt.query, got, tt.wantBob)
+		}
+	}
+}
+
+func TestSubscribersOnlyProfileRequiresApproval(t *testing.T) {
+	app := newTestApp(t)
+	app.createUser(t, "ann@example.com", time.Date(1990, time.May, 16, 0, 0, 0, 0, time.UTC))
+	bob := app.createUser(t, "bob@example.com", time.Date(1985, time.December, 30, 0, 0, 0, 0, time.UTC))
+	bobToken := app.login(t, "bob@example.com")
+	w := app.request(t, http.MethodPut, "/api/users/me/privacy", bobToken, types.PrivacySettings{Visibility: types.VisibilitySubscribers})
+	expectStatus(t, w, http.StatusOK)
+	annToken := app.login(t, "ann@example.com")
+	path := "/api/users/" + strconv.Itoa(bob)
+
+	w = app.request(t, http.MethodPost, path+"/subscribe", annToken, nil)
+	expectStatus(t, w, http.StatusAccepted)
+	w = app.request(t, http.MethodGet, path, annToken, nil)
+	expectStatus(t, w, http.StatusNotFound)
+
+	w = app.request(t, http.MethodGet, "/api/subscription-requests", bobToken, nil)
+	expectStatus(t, w, http.StatusOK)
+	requests := decode[[]types.SubscriptionRequest](t, w)
+	if len(requests) != 1 {
+		t.Fatalf("got requests %+v, want ann's", requests)
+	}
+	w = app.request(t, http.MethodPost, "/api/subscription-requests/"+strconv.Itoa(requests[0].ID)+"/approve", bobToken, nil)
+	expectStatus(t, w, http.StatusOK)
+	w = app.request(t, http.MethodGet, path, annToken, nil)
+	expectStatus(t, w, http.StatusOK)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"birthday/types"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+const (
+	maxReminderOffset  int = 30
+	maxReminderOffsets int = 5
+)
+
+func validateReminderOffsets(reminders types.ReminderOffsets) error {
+	if len(reminders.Normalize()) > maxReminderOffsets {
+		return fmt.Errorf("reminderOffsets must contain at most %d different offsets", maxReminderOffsets)
+	}
+	for _, offset := range reminders {
+		if offset < 0 || offset > maxReminderOffset {
+			return fmt.Errorf("reminderOffsets must be between 0 and %d days", maxReminderOffset)
+		}
+	}
+	return nil
+}
+
+// decodeSubscriptionSettings reads the optional body of the subscribe
+// endpoint, an empty one subscribes with a reminder on the day itself.
+func decodeSubscriptionSettings(w http.ResponseWriter, r *http.Request) (types.SubscriptionSettings, error) {
+	var settings types.SubscriptionSettings
+	err := json.NewDecoder(r.Body).Decode(&settings)
+	if err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusBadRequest, err)
+		return types.SubscriptionSettings{}, err
+	}
+	defer r.Body.Close()
+
+	err = validateReminderOffsets(settings.ReminderOffsets)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return types.SubscriptionSettings{}, err
+	}
+	return settings, nil
+}
+
+func (na *NotifyApp) subscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userId, id, err := subscribeUnsubscribeBase(w, r, vars)
+	if err != nil {
+		return
+	}
+
+	var subscription types.UserSubscription
+	switch r.Method {
+	case http.MethodGet:
+		subscription, err = na.dbConnection.GetSubscriptionSettings(userId, id)
+	case http.MethodPatch:
+		var settings types.SubscriptionSettings
+		settings, err = decodeSubscriptionSettings(w, r)
+		if err != nil {
+			return
+		}
+		if len(settings.ReminderOffsets) == 0 {
+			respondWithError(w, http.StatusBadRequest, errors.New("reminderOffsets field is required"))
+			return
+		}
+		subscription, err = na.dbConnection.UpdateSubscriptionSettings(userId, id, settings)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			respondWithError(w, http.StatusNotFound, errors.New("not subscribed to user's birthday with id "+vars["id"]))
+		default:
+			respondWithError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	respondWithJSON(w, http.StatusOK, subscription)
+}
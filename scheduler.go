@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"birthday/dates"
+	"birthday/db"
+	"birthday/notify"
+	"birthday/types"
+)
+
+const (
+	defaultNotifyTime string        = "09:00"
+	schedulerInterval time.Duration = time.Minute
+)
+
+type Scheduler struct {
+	dbConnection db.Store
+	notifier     notify.Notifier
+	// runAt is the offset from the subscriber's local midnight at which
+	// notifications are sent.
+	runAt time.Duration
+}
+
+func NewScheduler(dbConnection db.Store, notifier notify.Notifier, notifyTime string) (*Scheduler, error) {
+	if notifyTime == "" {
+		notifyTime = defaultNotifyTime
+	}
+	t, err := time.Parse("15:04", notifyTime)
+	if err != nil {
+		return nil, fmt.Errorf("invalid notification time %q, expected HH:MM: %w", notifyTime, err)
+	}
+	runAt := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	return &Scheduler{dbConnection: dbConnection, notifier: notifier, runAt: runAt}, nil
+}
+
+// Start launches the scheduler loop in the background. Every tick it sends
+// notifications to the subscribers whose local time has passed the run
+// time, and digests to those whose digest hour has come; the notifications
+// and digest deliveries tables keep a restart or a later tick from
+// notifying anybody twice on the same local day.
+func (s *Scheduler) Start() {
+	go func() {
+		s.RunOnce(time.Now())
+		ticker := time.NewTicker(schedulerInterval)
+		for now := range ticker.C {
+			s.RunOnce(now)
+		}
+	}()
+}
+
+func (s *Scheduler) RunOnce(now time.Time) {
+	timezones, err := s.dbConnection.GetTimezones()
+	if err != nil {
+		log.Printf("scheduler: failed to load time zones: %v\n", err)
+		return
+	}
+	offsets, err := s.dbConnection.GetReminderOffsets()
+	if err != nil {
+		log.Printf("scheduler: failed to load reminder offsets: %v\n", err)
+		return
+	}
+	for _, timezone := range timezones {
+		local := now.In(dates.Location(timezone))
+		today := dates.Day(local)
+		hour, minute, _ := local.Clock()
+		if time.Duration(hour)*time.Hour+time.Duration(minute)*time.Minute >= s.runAt {
+			s.notify(timezone, today, offsets)
+		}
+		s.sendDigests(timezone, types.DeliveryDaily, hour, today, today)
+		if local.Weekday() == time.Monday {
+			s.sendDigests(timezone, types.DeliveryWeekly, hour, today, today.AddDate(0, 0, 6))
+		}
+	}
+}
+
+// sendDigests sends the digest of the reminders due from from to to.
+// Subscribers without reminders in the period get no message, but the digest
+// is still recorded so they aren't looked at again until the next one.
+func (s *Scheduler) sendDigests(timezone, delivery string, hour int, from, to time.Time) {
+	subscribers, err := s.dbConnection.GetDigestSubscribers(timezone, delivery, hour, from)
+	if err != nil {
+		log.Printf("scheduler: failed to load %s digest subscribers: %v\n", delivery, err)
+		return
+	}
+	for _, subscriber := range subscribers {
+		birthdays, err := s.dbConnection.GetDigestBirthdays(subscriber.ID, from, to)
+		if err != nil {
+			log.Printf("scheduler: failed to build %s digest for user %d: %v\n", delivery, subscriber.ID, err)
+			continue
+		}
+		if len(birthdays) > 0 {
+			// Recorded even when a channel failed, as in notify.
+			err = s.notifier.NotifyDigest(subscriber, types.Digest{
+				Delivery:  delivery,
+				From:      from.Format(time.DateOnly),
+				To:        to.Format(time.DateOnly),
+				Birthdays: birthdays,
+			})
+			if err != nil {
+				log.Printf("scheduler: failed to send %s digest to user %d: %v\n", delivery, subscriber.ID, err)
+			}
+		}
+		err = s.dbConnection.RecordDigest(subscriber.ID, delivery, from)
+		if err != nil {
+			log.Printf("scheduler: failed to record %s digest for user %d: %v\n", delivery, subscriber.ID, err)
+		}
+	}
+}
+
+func (s *Scheduler) notify(timezone string, date time.Time, offsets types.ReminderOffsets) {
+	pending, err := s.dbConnection.GetPendingNotifications(timezone, date, offsets)
+	if err != nil {
+		log.Printf("scheduler: failed to load pending notifications: %v\n", err)
+		return
+	}
+	for _, p := range pending {
+		// The channels retry on their own, so the notifications are recorded
+		// even when one of them failed: trying again would send the reminder
+		// a second time through every channel that worked.
+		err = s.notifier.Notify(p.Subscriber, p.Birthdays)
+		if err != nil {
+			log.Printf("scheduler: failed to notify user %d: %v\n", p.Subscriber.ID, err)
+		}
+		birthdayUserIds := make([]int, 0, len(p.Birthdays))
+		for _, birthdayUser := range p.Birthdays {
+			birthdayUserIds = append(birthdayUserIds, birthdayUser.ID)
+		}
+		err = s.dbConnection.RecordNotifications(p.Subscriber.ID, birthdayUserIds, date)
+		if err != nil {
+			log.Printf("scheduler: failed to record notifications for user %d: %v\n", p.Subscriber.ID, err)
+		}
+	}
+}
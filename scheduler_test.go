@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"birthday/db"
+	"birthday/notify"
+	"birthday/types"
+)
+
+// countingNotifier counts what it was asked to deliver, and fails every time
+// when failing is set.
+type countingNotifier struct {
+	failing   bool
+	notified  int
+	digests   int
+	birthdays []types.UpcomingBirthday
+}
+
+func (n *countingNotifier) Notify(subscriber types.BirthdayUserResponse, birthdays []types.UpcomingBirthday) error {
+	n.notified++
+	n.birthdays = birthdays
+	if n.failing {
+		return errors.New("channel is down")
+	}
+	return nil
+}
+
+func (n *countingNotifier) NotifyDigest(subscriber types.BirthdayUserResponse, digest types.Digest) error {
+	n.digests++
+	if n.failing {
+		return errors.New("channel is down")
+	}
+	return nil
+}
+
+func TestFailedChannelIsNotRetried(t *testing.T) {
+	app := newTestApp(t)
+	now := time.Date(2024, time.May, 16, 12, 0, 0, 0, time.UTC)
+	ann := app.createUser(t, "ann@example.com", time.Date(1990, time.May, 1, 0, 0, 0, 0, time.UTC))
+	bob := app.createUser(t, "bob@example.com", time.Date(1990, time.May, 16, 0, 0, 0, 0, time.UTC))
+	err := app.db.SubscribeToUser(ann, bob, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	working, failing := &countingNotifier{}, &countingNotifier{failing: true}
+	scheduler, err := NewScheduler(app.db, notify.MultiNotifier{working, failing}, "09:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for minute := 0; minute < 3; minute++ {
+		scheduler.RunOnce(now.Add(time.Duration(minute) * time.Minute))
+	}
+	if working.notified != 1 || failing.notified != 1 {
+		t.Errorf("channels were asked %d and %d times, want once each", working.notified, failing.notified)
+	}
+	if len(working.birthdays) != 1 || working.birthdays[0].ID != bob {
+		t.Errorf("got birthdays %+v, want bob's", working.birthdays)
+	}
+}
+
+func TestDigestsFollowReminderOffsets(t *testing.T) {
+	app := newTestApp(t)
+	monday := time.Date(2024, time.May, 13, 12, 0, 0, 0, time.UTC)
+	ann := app.createUser(t, "ann@example.com", time.Date(1990, time.May, 1, 0, 0, 0, 0, time.UTC))
+	bob := app.createUser(t, "bob@example.com", time.Date(1990, time.May, 16, 0, 0, 0, 0, time.UTC))
+	carol := app.createUser(t, "carol@example.com", time.Date(1990, time.May, 22, 0, 0, 0, 0, time.UTC))
+	dave := app.createUser(t, "dave@example.com", time.Date(1990, time.May, 14, 0, 0, 0, 0, time.UTC))
+	for _, subscription := range []struct {
+		id        int
+		reminders types.ReminderOffsets
+	}{{bob, nil}, {carol, types.ReminderOffsets{7}}, {dave, types.ReminderOffsets{3}}} {
+		err := app.db.SubscribeToUser(ann, subscription.id, subscription.reminders)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		delivery string
+		want     []int
+	}{
+		// Dave's reminder was due the Saturday before.
+		{types.DeliveryWeekly, []int{bob, carol}},
+		// Only carol's reminder is due on Wednesday, bob's birthday is Thursday.
+		{types.DeliveryDaily, []int{carol}},
+	}
+	for _, tt := range tests {
+		err := app.db.UpdateDeliveryPreferences(ann, types.DeliveryPreferences{Delivery: tt.delivery, DigestHour: 9})
+		if err != nil {
+			t.Fatal(err)
+		}
+		now := monday
+		if tt.delivery == types.DeliveryDaily {
+			now = monday.AddDate(0, 0, 2)
+		}
+		notifier := &digestRecorder{}
+		scheduler, err := NewScheduler(app.db, notifier, "09:00")
+		if err != nil {
+			t.Fatal(err)
+		}
+		scheduler.RunOnce(now)
+		scheduler.RunOnce(now.Add(time.Minute))
+		if len(notifier.digests) != 1 {
+			t.Fatalf("%s: sent %d digests, want a single one even though it failed", tt.delivery, len(notifier.digests))
+		}
+		var got []int
+		for _, birthday := range notifier.digests[0].Birthdays {
+			got = append(got, birthday.ID)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s digest lists %v, want %v", tt.delivery, got, tt.want)
+		}
+	}
+}
+
+// digestRecorder keeps the digests it is asked to deliver and fails them all.
+type digestRecorder struct {
+	digests []types.Digest
+}
+
+func (n *digestRecorder) Notify(subscriber types.BirthdayUserResponse, birthdays []types.UpcomingBirthday) error {
+	return nil
+}
+
+func (n *digestRecorder) NotifyDigest(subscriber types.BirthdayUserResponse, digest types.Digest) error {
+	n.digests = append(n.digests, digest)
+	return errors.New("channel is down")
+}
+
+// offsetCountingStore counts how often the reminder offsets are loaded.
+type offsetCountingStore struct {
+	db.Store
+	loads int
+}
+
+func (s *offsetCountingStore) GetReminderOffsets() (types.ReminderOffsets, error) {
+	s.loads++
+	return s.Store.GetReminderOffsets()
+}
+
+func TestReminderOffsetsLoadedOncePerRun(t *testing.T) {
+	app := newTestApp(t)
+	now := time.Date(2024, time.May, 9, 12, 0, 0, 0, time.UTC)
+	bob := app.createUser(t, "bob@example.com", time.Date(1990, time.May, 16, 0, 0, 0, 0, time.UTC))
+	for _, subscriber := range []struct{ email, timezone string }{
+		{"ann@example.com", "UTC"}, {"carol@example.com", "Europe/Berlin"}, {"dave@example.com", "Asia/Tokyo"},
+	} {
+		id := app.createUser(t, subscriber.email, time.Date(1990, time.May, 1, 0, 0, 0, 0, time.UTC))
+		err := app.db.DB.Model(&types.BirthdayUser{}).Where("id = ?", id).Update("timezone", subscriber.timezone).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = app.db.SubscribeToUser(id, bob, types.ReminderOffsets{7})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store := &offsetCountingStore{Store: app.db}
+	notifier := &countingNotifier{}
+	scheduler, err := NewScheduler(store, notifier, "09:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	scheduler.RunOnce(now)
+	if store.loads != 1 {
+		t.Errorf("loaded the reminder offsets %d times, want once", store.loads)
+	}
+	if notifier.notified != 3 {
+		t.Errorf("notified %d subscribers, want the 3 reminded a week ahead", notifier.notified)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+func (na *NotifyApp) getSubscriptionRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := currentUserId(w, r)
+	if err != nil {
+		return
+	}
+	requests, err := na.dbConnection.GetSubscriptionRequests(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, requests)
+}
+
+func (na *NotifyApp) approveSubscriptionRequestHandler(w http.ResponseWriter, r *http.Request) {
+	na.decideSubscriptionRequest(w, r, na.dbConnection.ApproveSubscriptionRequest, "approved")
+}
+
+func (na *NotifyApp) rejectSubscriptionRequestHandler(w http.ResponseWriter, r *http.Request) {
+	na.decideSubscriptionRequest(w, r, na.dbConnection.RejectSubscriptionRequest, "rejected")
+}
+
+func (na *NotifyApp) decideSubscriptionRequest(w http.ResponseWriter, r *http.Request, decide func(userId, id int) error, decision string) {
+	userId, err := currentUserId(w, r)
+	if err != nil {
+		return
+	}
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, errors.New("invalid subscription request id"))
+		return
+	}
+
+	err = decide(userId, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			respondWithError(w, http.StatusNotFound, err)
+		default:
+			respondWithError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	respondWithJSON(w, http.StatusOK, decision+" subscription request with id "+vars["id"])
+}
@@ -1,12 +1,21 @@
 package types
 
-import "time"
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
 
 type BirthdayUserBase struct {
 	FirstName string    `json:"firstName"`
 	LastName  string    `json:"lastName"`
-	Email     string    `json:"email"`
+	Email     string    `json:"email,omitempty"`
 	Birthday  time.Time `json:"birthday"`
+	Timezone  string    `json:"timezone" gorm:"not null;default:''"`
 }
 
 type BirthdayUserRequest struct {
@@ -17,19 +26,548 @@ type BirthdayUserRequest struct {
 type BirthdayUserResponse struct {
 	ID int `json:"id"`
 	BirthdayUserBase
+	PrivacySettings `json:"-"`
+	// yearHidden is set by Viewer.Redact when the birth year must not be shown.
+	yearHidden bool
+}
+
+// BirthYearHidden reports whether the birth year was redacted, in which case
+// only the month and day of Birthday may be shown.
+func (u BirthdayUserResponse) BirthYearHidden() bool {
+	return u.yearHidden
+}
+
+// BirthDate formats the birthday as YYYY-MM-DD, or as --MM-DD when the birth
+// year is hidden.
+func (u BirthdayUserResponse) BirthDate() string {
+	if u.yearHidden {
+		return u.Birthday.Format("--01-02")
+	}
+	return u.Birthday.Format(time.DateOnly)
+}
+
+// userJSON replaces the birthday with its year-less form when needed. The
+// plain type drops MarshalJSON to avoid recursing into it.
+type userJSON struct {
+	plainUser
+	Birthday any `json:"birthday"`
+}
+
+type plainUser BirthdayUserResponse
+
+func (u BirthdayUserResponse) toJSON() userJSON {
+	view := userJSON{plainUser: plainUser(u), Birthday: u.Birthday}
+	if u.yearHidden {
+		view.Birthday = u.BirthDate()
+	}
+	return view
+}
+
+func (u BirthdayUserResponse) MarshalJSON() ([]byte, error) {
+	return json.Marshal(u.toJSON())
 }
 
+const (
+	VisibilityEveryone    string = "everyone"
+	VisibilitySubscribers string = "subscribers"
+	VisibilityNobody      string = "nobody"
+)
+
+// PrivacySettings control what other users can see of a profile. Admins and
+// the user themselves always see everything.
+type PrivacySettings struct {
+	HideBirthYear bool `json:"hideBirthYear" gorm:"not null;default:false"`
+	HideEmail     bool `json:"hideEmail" gorm:"not null;default:false"`
+	// Visibility is one of VisibilityEveryone, VisibilitySubscribers (only
+	// users subscribed to the profile) or VisibilityNobody.
+	Visibility string `json:"visibility" gorm:"not null;default:'everyone'"`
+	// RequireSubscriptionApproval turns subscribing into a request the user
+	// has to approve.
+	RequireSubscriptionApproval bool `json:"requireSubscriptionApproval" gorm:"not null;default:false"`
+}
+
+// ApprovalRequired tells whether subscribing needs the user's approval. It is
+// always needed for profiles not visible to everyone, as subscribers can see
+// them.
+func (s PrivacySettings) ApprovalRequired() bool {
+	return s.RequireSubscriptionApproval || s.Visibility != VisibilityEveryone
+}
+
+// Viewer is the user a response is prepared for. The zero value is an
+// anonymous caller.
+type Viewer struct {
+	ID    int
+	Admin bool
+}
+
+// Redact removes what the privacy settings of user hide from the viewer.
+func (v Viewer) Redact(user BirthdayUserResponse) BirthdayUserResponse {
+	if v.Admin || v.ID == user.ID {
+		return user
+	}
+	if user.HideEmail {
+		user.Email = ""
+	}
+	user.yearHidden = user.HideBirthYear
+	return user
+}
+
+const (
+	DeliveryInstant string = "instant"
+	DeliveryDaily   string = "daily"
+	DeliveryWeekly  string = "weekly"
+)
+
+// DeliveryPreferences choose how a subscriber hears about birthdays: a
+// notification on the day at the configured time, a daily digest or a weekly
+// digest on Monday listing the coming week.
+type DeliveryPreferences struct {
+	Delivery string `json:"delivery" gorm:"not null;default:'instant'"`
+	// DigestHour is the local hour daily and weekly digests are sent at.
+	DigestHour int `json:"digestHour" gorm:"not null;default:9"`
+}
+
+const (
+	RoleUser  string = "user"
+	RoleAdmin string = "admin"
+)
+
 type BirthdayUser struct {
 	ID            int             `json:"id" gorm:"primaryKey"`
 	Subscriptions []*BirthdayUser `json:"-" gorm:"many2many:user_subscriptions"`
+	Role          string          `json:"-" gorm:"not null;default:'user'"`
+	// CalendarTokenHash authenticates the calendar feed URL of the user.
+	CalendarTokenHash string `json:"-" gorm:"index"`
+	// EmailVerified is set once the user confirms owning Email, and reset
+	// whenever Email changes.
+	EmailVerified       bool `json:"-" gorm:"not null;default:false"`
+	PrivacySettings     `json:"-"`
+	DeliveryPreferences `json:"-"`
 	BirthdayUserRequest
 }
 
+// ReminderOffsets are the numbers of days before a birthday a subscriber is
+// reminded of it, 0 being the day itself. They are stored as a comma
+// separated list, like "7,1,0".
+type ReminderOffsets []int
+
+var DefaultReminderOffsets = ReminderOffsets{0}
+
+func (o ReminderOffsets) Value() (driver.Value, error) {
+	offsets := make([]string, 0, len(o))
+	for _, offset := range o {
+		offsets = append(offsets, strconv.Itoa(offset))
+	}
+	return strings.Join(offsets, ","), nil
+}
+
+func (o *ReminderOffsets) Scan(value any) error {
+	var list string
+	switch v := value.(type) {
+	case string:
+		list = v
+	case []byte:
+		list = string(v)
+	default:
+		return fmt.Errorf("unsupported reminder offsets value %T", value)
+	}
+	*o = ReminderOffsets{}
+	for _, offset := range strings.Split(list, ",") {
+		if offset == "" {
+			continue
+		}
+		days, err := strconv.Atoi(offset)
+		if err != nil {
+			return err
+		}
+		*o = append(*o, days)
+	}
+	return nil
+}
+
+// Normalize sorts the offsets from the earliest reminder to the day itself
+// and drops the duplicates.
+func (o ReminderOffsets) Normalize() ReminderOffsets {
+	offsets := slices.Clone(o)
+	slices.SortFunc(offsets, func(a, b int) int { return b - a })
+	return slices.Compact(offsets)
+}
+
+// UserSubscription is the join table behind BirthdayUser.Subscriptions.
+type UserSubscription struct {
+	BirthdayUserID  int             `json:"-" gorm:"primaryKey"`
+	SubscriptionID  int             `json:"userId" gorm:"primaryKey"`
+	ReminderOffsets ReminderOffsets `json:"reminderOffsets" gorm:"type:text;not null;default:'0'"`
+}
+
+type SubscriptionSettings struct {
+	ReminderOffsets ReminderOffsets `json:"reminderOffsets"`
+}
+
+// UserQuery narrows down and orders the users listing.
+type UserQuery struct {
+	Search         string
+	Month          int
+	BornFrom       time.Time
+	BornTo         time.Time
+	SortBy         string
+	SortDescending bool
+}
+
+// Page is the envelope of paginated listings. Page is only set when the
+// page was requested by number rather than by cursor.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"nextCursor,omitempty"`
+	TotalCount int64  `json:"totalCount"`
+	Page       int    `json:"page,omitempty"`
+	PageSize   int    `json:"pageSize"`
+}
+
 type LoginRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
 }
 
 type Token struct {
-	Token string `json:"access_token"`
+	Token        string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// JWK is a public key that access tokens can be verified with, as described
+// in RFC 7517. RSA keys have N and E, Ed25519 keys Crv and X.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type Session struct {
+	ID        int        `json:"id" gorm:"primaryKey"`
+	UserID    int        `json:"userId" gorm:"index"`
+	RevokedAt *time.Time `json:"revokedAt"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+const (
+	ScopeRead      string = "read"
+	ScopeReadWrite string = "read-write"
+)
+
+// APIKey lets scripts act as the user without their password. Only the
+// SHA-256 hash of the key is stored, Prefix is kept so that users can tell
+// their keys apart.
+type APIKey struct {
+	ID         int        `json:"id" gorm:"primaryKey"`
+	UserID     int        `json:"-" gorm:"index"`
+	Name       string     `json:"name" gorm:"not null"`
+	Prefix     string     `json:"prefix" gorm:"not null"`
+	KeyHash    string     `json:"-" gorm:"uniqueIndex"`
+	Scope      string     `json:"scope" gorm:"not null"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+type APIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scope     string     `json:"scope"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// NewAPIKey is the only response the key itself is ever returned in.
+type NewAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// RefreshToken is a single link in the rotation chain of a session. Only the
+// SHA-256 hash of the token is stored.
+type RefreshToken struct {
+	ID        int        `json:"id" gorm:"primaryKey"`
+	SessionID int        `json:"sessionId" gorm:"index"`
+	TokenHash string     `json:"-" gorm:"uniqueIndex"`
+	UsedAt    *time.Time `json:"usedAt"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+const (
+	ThrottleAccount string = "account"
+	ThrottleIP      string = "ip"
+	// ThrottlePasswordReset counts the password reset requests and the
+	// failed resets from an IP address.
+	ThrottlePasswordReset string = "password-reset"
+	// ThrottleEmailVerification counts the requests for a new email
+	// verification token from an IP address.
+	ThrottleEmailVerification string = "email-verification"
+)
+
+// LoginThrottle counts the recent failed logins to an account, identified by
+// the email that was tried whether it exists or not, or from an IP address,
+// and blocks further attempts until BlockedUntil. Password resets from an IP
+// address are counted the same way.
+type LoginThrottle struct {
+	ID            int       `json:"id" gorm:"primaryKey"`
+	Kind          string    `json:"kind" gorm:"not null;uniqueIndex:idx_login_throttle"`
+	Subject       string    `json:"subject" gorm:"not null;uniqueIndex:idx_login_throttle"`
+	Failures      int       `json:"failures" gorm:"not null;default:0"`
+	LastFailureAt time.Time `json:"lastFailureAt"`
+	BlockedUntil  time.Time `json:"blockedUntil"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// PasswordResetToken is a single-use token emailed to a user who forgot their
+// password. Only the SHA-256 hash of the token is stored.
+type PasswordResetToken struct {
+	ID        int        `json:"id" gorm:"primaryKey"`
+	UserID    int        `json:"-" gorm:"index"`
+	TokenHash string     `json:"-" gorm:"uniqueIndex"`
+	UsedAt    *time.Time `json:"usedAt"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+type ResendVerificationRequest struct {
+	Email string `json:"email"`
+}
+
+// EmailVerificationToken is a single-use token emailed to confirm that a user
+// owns Email. Only the SHA-256 hash of the token is stored.
+type EmailVerificationToken struct {
+	ID        int       `json:"id" gorm:"primaryKey"`
+	UserID    int       `json:"-" gorm:"index"`
+	Email     string    `json:"email"`
+	TokenHash string    `json:"-" gorm:"uniqueIndex"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// SubscriptionRequest is a pending subscription of SubscriberID to the
+// birthday of UserID, waiting for UserID to approve or reject it.
+type SubscriptionRequest struct {
+	ID           int                   `json:"id" gorm:"primaryKey"`
+	SubscriberID int                   `json:"subscriberId" gorm:"not null;uniqueIndex:idx_subscription_request"`
+	UserID       int                   `json:"userId" gorm:"not null;uniqueIndex:idx_subscription_request;index"`
+	Subscriber   *BirthdayUserResponse `json:"subscriber,omitempty" gorm:"-"`
+	// ReminderOffsets are those of the subscription once it is approved.
+	ReminderOffsets ReminderOffsets `json:"reminderOffsets" gorm:"type:text;not null;default:'0'"`
+	CreatedAt       time.Time       `json:"createdAt"`
+}
+
+type GroupRequest struct {
+	Name string `json:"name"`
+}
+
+type Group struct {
+	ID        int       `json:"id" gorm:"primaryKey"`
+	Name      string    `json:"name" gorm:"not null"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type GroupMember struct {
+	GroupID   int       `json:"groupId" gorm:"primaryKey"`
+	UserID    int       `json:"userId" gorm:"primaryKey;index"`
+	Owner     bool      `json:"owner" gorm:"not null;default:false"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type GroupMemberRequest struct {
+	UserID int  `json:"userId"`
+	Owner  bool `json:"owner"`
+}
+
+// GroupSubscription subscribes SubscriberID to the birthdays of every member
+// of GroupID, including members added later.
+type GroupSubscription struct {
+	SubscriberID int       `json:"subscriberId" gorm:"primaryKey"`
+	GroupID      int       `json:"groupId" gorm:"primaryKey;index"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+type GroupMemberResponse struct {
+	User  BirthdayUserResponse `json:"user"`
+	Owner bool                 `json:"owner"`
+}
+
+// GroupDetails lists the members of a group the viewer is allowed to see,
+// MemberCount counts all of them.
+type GroupDetails struct {
+	Group
+	MemberCount int                   `json:"memberCount"`
+	Members     []GroupMemberResponse `json:"members"`
+}
+
+type Notification struct {
+	ID             int       `json:"id" gorm:"primaryKey"`
+	SubscriberID   int       `json:"subscriberId" gorm:"uniqueIndex:idx_notification_sent"`
+	BirthdayUserID int       `json:"birthdayUserId" gorm:"uniqueIndex:idx_notification_sent"`
+	Date           string    `json:"date" gorm:"uniqueIndex:idx_notification_sent"`
+	SentAt         time.Time `json:"sentAt"`
+}
+
+// DigestDelivery records that the digest of a period starting on Date was
+// sent to a subscriber, so it goes out only once.
+type DigestDelivery struct {
+	ID           int       `json:"id" gorm:"primaryKey"`
+	SubscriberID int       `json:"subscriberId" gorm:"uniqueIndex:idx_digest_sent"`
+	Delivery     string    `json:"delivery" gorm:"uniqueIndex:idx_digest_sent"`
+	Date         string    `json:"date" gorm:"uniqueIndex:idx_digest_sent"`
+	SentAt       time.Time `json:"sentAt"`
+}
+
+// Digest aggregates the birthdays from From to To into a single message.
+type Digest struct {
+	Delivery  string             `json:"delivery"`
+	From      string             `json:"from"`
+	To        string             `json:"to"`
+	Birthdays []UpcomingBirthday `json:"birthdays"`
+}
+
+type PendingNotification struct {
+	Subscriber BirthdayUserResponse
+	Birthdays  []UpcomingBirthday
+}
+
+type WebhookRequest struct {
+	URL     string `json:"url"`
+	Enabled *bool  `json:"enabled,omitempty"`
+}
+
+type Webhook struct {
+	ID                  int        `json:"id" gorm:"primaryKey"`
+	UserID              int        `json:"-" gorm:"index"`
+	URL                 string     `json:"url"`
+	Secret              string     `json:"secret,omitempty"`
+	Enabled             bool       `json:"enabled"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	DisabledAt          *time.Time `json:"disabledAt"`
+	CreatedAt           time.Time  `json:"createdAt"`
+}
+
+type WebhookDelivery struct {
+	ID             int       `json:"id" gorm:"primaryKey"`
+	WebhookID      int       `json:"webhookId" gorm:"index"`
+	BirthdayUserID int       `json:"birthdayUserId"`
+	Attempt        int       `json:"attempt"`
+	StatusCode     int       `json:"statusCode"`
+	Success        bool      `json:"success"`
+	Error          string    `json:"error,omitempty"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+type BirthdayEvent struct {
+	Event string `json:"event"`
+	// Date is the date of the birthday, DaysUntil days away for a reminder.
+	Date      string               `json:"date"`
+	DaysUntil int                  `json:"daysUntil"`
+	User      BirthdayUserResponse `json:"user"`
+}
+
+type DigestEvent struct {
+	Event string `json:"event"`
+	Digest
+}
+
+type UpcomingBirthday struct {
+	BirthdayUserResponse
+	NextBirthday string `json:"nextBirthday"`
+	DaysUntil    int    `json:"daysUntil"`
+	TurningAge   int    `json:"turningAge"`
+}
+
+// MarshalJSON leaves out the age when the birth year is hidden. It is needed
+// anyway, as the promoted BirthdayUserResponse.MarshalJSON would drop the
+// other fields.
+func (u UpcomingBirthday) MarshalJSON() ([]byte, error) {
+	view := struct {
+		userJSON
+		NextBirthday string `json:"nextBirthday"`
+		DaysUntil    int    `json:"daysUntil"`
+		TurningAge   *int   `json:"turningAge,omitempty"`
+	}{userJSON: u.toJSON(), NextBirthday: u.NextBirthday, DaysUntil: u.DaysUntil}
+	if !u.BirthYearHidden() {
+		view.TurningAge = &u.TurningAge
+	}
+	return json.Marshal(view)
+}
+
+type CalendarFeed struct {
+	URL   string `json:"url"`
+	Token string `json:"token"`
+}
+
+const (
+	ImportStatusCreated string = "created"
+	ImportStatusValid   string = "valid"
+	ImportStatusSkipped string = "skipped"
+	ImportStatusFailed  string = "failed"
+)
+
+type ImportResult struct {
+	Row    int    `json:"row"`
+	Email  string `json:"email"`
+	Status string `json:"status"`
+	ID     int    `json:"id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type ImportReport struct {
+	DryRun  bool           `json:"dryRun"`
+	Atomic  bool           `json:"atomic"`
+	Created int            `json:"created"`
+	Failed  int            `json:"failed"`
+	Rows    []ImportResult `json:"rows"`
+}
+
+// UserDataExport is everything stored about a user, returned by the personal
+// data export.
+type UserDataExport struct {
+	ExportedAt              time.Time                `json:"exportedAt"`
+	Profile                 BirthdayUserResponse     `json:"profile"`
+	Role                    string                   `json:"role"`
+	EmailVerified           bool                     `json:"emailVerified"`
+	Privacy                 PrivacySettings          `json:"privacy"`
+	DeliveryPreferences     DeliveryPreferences      `json:"deliveryPreferences"`
+	CalendarFeedEnabled     bool                     `json:"calendarFeedEnabled"`
+	Subscriptions           []BirthdayUserResponse   `json:"subscriptions"`
+	SubscriptionSettings    []UserSubscription       `json:"subscriptionSettings"`
+	SubscriberIDs           []int                    `json:"subscriberIds"`
+	SubscriptionRequests    []SubscriptionRequest    `json:"subscriptionRequests"`
+	GroupMemberships        []GroupMember            `json:"groupMemberships"`
+	GroupSubscriptions      []GroupSubscription      `json:"groupSubscriptions"`
+	Notifications           []Notification           `json:"notifications"`
+	DigestDeliveries        []DigestDelivery         `json:"digestDeliveries"`
+	Webhooks                []Webhook                `json:"webhooks"`
+	WebhookDeliveries       []WebhookDelivery        `json:"webhookDeliveries"`
+	Sessions                []Session                `json:"sessions"`
+	PasswordResetTokens     []PasswordResetToken     `json:"passwordResetTokens"`
+	EmailVerificationTokens []EmailVerificationToken `json:"emailVerificationTokens"`
+	APIKeys                 []APIKey                 `json:"apiKeys"`
+	LoginThrottles          []LoginThrottle          `json:"loginThrottles"`
 }
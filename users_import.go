@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"birthday/types"
+	"birthday/vcard"
+)
+
+const (
+	maxImportSize int64 = 10 << 20
+
+	importFormatCSV   string = "csv"
+	importFormatVCard string = "vcard"
+)
+
+type importRow struct {
+	row  int
+	user types.BirthdayUserRequest
+	err  error
+}
+
+func importFormat(r *http.Request) (string, error) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		if format != importFormatCSV && format != importFormatVCard {
+			return "", fmt.Errorf("unsupported format %q, expected %s or %s", format, importFormatCSV, importFormatVCard)
+		}
+		return format, nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/csv":
+		return importFormatCSV, nil
+	case "text/vcard", "text/x-vcard", "text/directory":
+		return importFormatVCard, nil
+	default:
+		return "", errors.New("set the format query parameter or a text/csv or text/vcard Content-Type")
+	}
+}
+
+// parseCSVImport reads rows with a header naming the columns. firstName,
+// lastName, email and birthday are required, password and timezone are
+// optional.
+func parseCSVImport(body io.Reader) ([]importRow, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading CSV header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"firstname", "lastname", "email", "birthday"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV header is missing the %s column", required)
+		}
+	}
+
+	var rows []importRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				rows = append(rows, importRow{row: parseErr.StartLine, err: err})
+				continue
+			}
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+
+		field := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+		row := importRow{row: line}
+		row.user.FirstName = field("firstname")
+		row.user.LastName = field("lastname")
+		row.user.Email = field("email")
+		row.user.Password = field("password")
+		row.user.Timezone = field("timezone")
+		row.user.Birthday, row.err = parseDate(field("birthday"))
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func parseVCardImport(body io.Reader) ([]importRow, error) {
+	cards, err := vcard.Parse(body)
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]importRow, 0, len(cards))
+	for _, card := range cards {
+		row := importRow{row: card.Line}
+		row.user.FirstName = card.FirstName
+		row.user.LastName = card.LastName
+		if row.user.FirstName == "" && row.user.LastName == "" {
+			if i := strings.LastIndex(card.FullName, " "); i >= 0 {
+				row.user.FirstName, row.user.LastName = card.FullName[:i], card.FullName[i+1:]
+			} else {
+				row.user.FirstName = card.FullName
+			}
+		}
+		row.user.Email = card.Email
+		if card.Birthday != "" {
+			row.user.Birthday, row.err = vcard.ParseDate(card.Birthday)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func (na *NotifyApp) importUsersHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	dryRun, _ := strconv.ParseBool(q.Get("dry_run"))
+	atomic, _ := strconv.ParseBool(q.Get("atomic"))
+	format, err := importFormat(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxImportSize)
+	defer body.Close()
+	var rows []importRow
+	if format == importFormatCSV {
+		rows, err = parseCSVImport(body)
+	} else {
+		rows, err = parseVCardImport(body)
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	report := types.ImportReport{DryRun: dryRun, Atomic: atomic, Rows: make([]types.ImportResult, len(rows))}
+	var users []types.BirthdayUser
+	var userRows []int
+	for i, row := range rows {
+		row.user.Email = strings.ToLower(strings.TrimSpace(row.user.Email))
+		report.Rows[i] = types.ImportResult{Row: row.row, Email: row.user.Email}
+		if row.err == nil && row.user.Password == "" {
+			// Imported users set their own password through the password reset flow.
+			row.user.Password, row.err = generateToken()
+		}
+		if row.err == nil {
+			row.err = validateBirthdayUser(row.user)
+		}
+		if row.err != nil {
+			report.Rows[i].Status = types.ImportStatusFailed
+			report.Rows[i].Error = row.err.Error()
+			report.Failed++
+			continue
+		}
+		users = append(users, types.BirthdayUser{BirthdayUserRequest: row.user})
+		userRows = append(userRows, i)
+	}
+
+	rollback := dryRun || (atomic && report.Failed > 0)
+	results, err := na.dbConnection.ImportUsers(users, rollback, atomic)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	for i, result := range results {
+		result.Row = report.Rows[userRows[i]].Row
+		if !dryRun && result.Status == types.ImportStatusValid {
+			result.Status = types.ImportStatusSkipped
+		}
+		switch result.Status {
+		case types.ImportStatusCreated:
+			report.Created++
+		case types.ImportStatusFailed:
+			report.Failed++
+		}
+		report.Rows[userRows[i]] = result
+	}
+
+	switch {
+	case dryRun:
+		respondWithJSON(w, http.StatusOK, report)
+	case report.Created > 0:
+		respondWithJSON(w, http.StatusCreated, report)
+	default:
+		respondWithJSON(w, http.StatusBadRequest, report)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseCSVImport(t *testing.T) {
+	tests := []struct {
+		name string
+		csv  string
+		// rows are the line numbers of the rows, failed ones negated.
+		rows []int
+	}{
+		{"valid", "firstName,lastName,email,birthday\nAnn,Lee,ann@example.com,1990-05-16\nBob,Ray,bob@example.com,1991-06-01\n", []int{2, 3}},
+		{"malformed first row", "firstName,lastName,email,birthday\nAnn,\"Lee,ann@example.com,1990-05-16\n", []int{-2}},
+		{"malformed later row", "firstName,lastName,email,birthday\nAnn,Lee,ann@example.com,1990-05-16\nBob,R\"ay,bob@example.com,1991-06-01\nCat,Fox,cat@example.com,1992-07-02\n", []int{2, -3, 4}},
+		{"malformed first field", "firstName,lastName,email,birthday\n\"A\"nn,Lee,ann@example.com,1990-05-16\nBob,Ray,bob@example.com,1991-06-01\n", []int{-2, 3}},
+		{"invalid date", "firstName,lastName,email,birthday\nAnn,Lee,ann@example.com,16.05.1990\n", []int{-2}},
+	}
+	for _, tt := range tests {
+		rows, err := parseCSVImport(strings.NewReader(tt.csv))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var got []int
+		for _, row := range rows {
+			if row.err != nil {
+				got = append(got, -row.row)
+			} else {
+				got = append(got, row.row)
+			}
+		}
+		if !slices.Equal(got, tt.rows) {
+			t.Errorf("%s: got rows %v, want %v", tt.name, got, tt.rows)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package vcard
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Card holds the vCard 3.0/4.0 properties the service cares about.
+type Card struct {
+	// Line is the line number of BEGIN:VCARD, for error reporting.
+	Line      int
+	FirstName string
+	LastName  string
+	FullName  string
+	Email     string
+	Birthday  string
+}
+
+// Parse reads every vCard from r. Lines are unfolded, property groups and
+// parameters are ignored apart from the property name.
+func Parse(r io.Reader) ([]Card, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var cards []Card
+	var card *Card
+	for _, l := range lines {
+		name, value, ok := strings.Cut(l.text, ":")
+		if !ok {
+			continue
+		}
+		name, _, _ = strings.Cut(name, ";")
+		if i := strings.LastIndex(name, "."); i >= 0 {
+			name = name[i+1:]
+		}
+		name = strings.ToUpper(name)
+
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VCARD"):
+			if card != nil {
+				return nil, fmt.Errorf("line %d: nested BEGIN:VCARD", l.number)
+			}
+			card = &Card{Line: l.number}
+		case name == "END" && strings.EqualFold(value, "VCARD"):
+			if card == nil {
+				return nil, fmt.Errorf("line %d: END:VCARD without BEGIN:VCARD", l.number)
+			}
+			cards = append(cards, *card)
+			card = nil
+		case card == nil:
+			continue
+		case name == "N":
+			parts := strings.Split(value, ";")
+			card.LastName = unescape(parts[0])
+			if len(parts) > 1 {
+				card.FirstName = unescape(parts[1])
+			}
+		case name == "FN":
+			card.FullName = unescape(value)
+		case name == "EMAIL" && card.Email == "":
+			card.Email = unescape(value)
+		case name == "BDAY":
+			card.Birthday = value
+		}
+	}
+	if card != nil {
+		return nil, errors.New("missing END:VCARD at the end of input")
+	}
+	return cards, nil
+}
+
+// ParseDate parses a BDAY value. Both the basic (19900520) and extended
+// (1990-05-20) forms are accepted, with an optional time part. Dates
+// without a year are rejected.
+func ParseDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "--") {
+		return time.Time{}, errors.New("birthday without a year is not supported")
+	}
+	date, _, _ := strings.Cut(value, "T")
+	for _, layout := range []string{"20060102", time.DateOnly} {
+		if t, err := time.Parse(layout, date); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid birthday %q", value)
+}
+
+type line struct {
+	number int
+	text   string
+}
+
+func unfold(r io.Reader) ([]line, error) {
+	var lines []line
+	scanner := bufio.NewScanner(r)
+	number := 0
+	for scanner.Scan() {
+		number++
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(text, " ") || strings.HasPrefix(text, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1].text += text[1:]
+			continue
+		}
+		if text != "" {
+			lines = append(lines, line{number, text})
+		}
+	}
+	return lines, scanner.Err()
+}
+
+func unescape(value string) string {
+	return strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(value)
+}
+
+// Write encodes card as a vCard 3.0 with CRLF line endings.
+func Write(w io.Writer, card Card) error {
+	fullName := card.FullName
+	if fullName == "" {
+		fullName = strings.TrimSpace(card.FirstName + " " + card.LastName)
+	}
+	lines := []string{
+		"BEGIN:VCARD",
+		"VERSION:3.0",
+		"N:" + escape(card.LastName) + ";" + escape(card.FirstName) + ";;;",
+		"FN:" + escape(fullName),
+	}
+	if card.Email != "" {
+		lines = append(lines, "EMAIL;TYPE=INTERNET:"+escape(card.Email))
+	}
+	if card.Birthday != "" {
+		lines = append(lines, "BDAY:"+card.Birthday)
+	}
+	lines = append(lines, "END:VCARD")
+	_, err := io.WriteString(w, strings.Join(lines, "\r\n")+"\r\n")
+	return err
+}
+
+func escape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\r\n", `\n`, "\n", `\n`).Replace(value)
+}
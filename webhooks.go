@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"birthday/notify"
+	"birthday/types"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+func validateWebhook(webhook types.WebhookRequest, partial bool) error {
+	if webhook.URL == "" {
+		if partial {
+			return nil
+		}
+		return errors.New("url field is required")
+	}
+	u, err := url.Parse(webhook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	return notify.CheckWebhookHost(u.Hostname())
+}
+
+func generateWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func respondWithWebhookError(w http.ResponseWriter, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		respondWithError(w, http.StatusNotFound, errors.New("webhook not found"))
+		return
+	}
+	respondWithError(w, http.StatusInternalServerError, err)
+}
+
+func (na *NotifyApp) getWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := currentUserId(w, r)
+	if err != nil {
+		return
+	}
+	webhooks, err := na.dbConnection.GetWebhooks(userId)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+
+	respondWithJSON(w, http.StatusOK, webhooks)
+}
+
+func (na *NotifyApp) createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := currentUserId(w, r)
+	if err != nil {
+		return
+	}
+	var webhookRequest types.WebhookRequest
+	err = json.NewDecoder(r.Body).Decode(&webhookRequest)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer r.Body.Close()
+
+	err = validateWebhook(webhookRequest, false)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+	secret, err := generateWebhookSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	webhook := types.Webhook{UserID: userId, URL: webhookRequest.URL, Secret: secret, Enabled: true}
+	if webhookRequest.Enabled != nil {
+		webhook.Enabled = *webhookRequest.Enabled
+	}
+	createdWebhook, err := na.dbConnection.CreateWebhook(webhook)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// The secret is only ever shown once, right after the webhook is created.
+	respondWithJSON(w, http.StatusCreated, createdWebhook)
+}
+
+func (na *NotifyApp) webhookHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := currentUserId(w, r)
+	if err != nil {
+		return
+	}
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, errors.New("invalid webhook id"))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		webhook, err := na.dbConnection.GetWebhook(userId, id)
+		if err != nil {
+			respondWithWebhookError(w, err)
+			return
+		}
+		webhook.Secret = ""
+		respondWithJSON(w, http.StatusOK, webhook)
+	case http.MethodPut, http.MethodPatch:
+		var webhookRequest types.WebhookRequest
+		err = json.NewDecoder(r.Body).Decode(&webhookRequest)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+		defer r.Body.Close()
+
+		err = validateWebhook(webhookRequest, r.Method == http.MethodPatch)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err)
+			return
+		}
+		webhook, err := na.dbConnection.UpdateWebhook(userId, id, webhookRequest)
+		if err != nil {
+			respondWithWebhookError(w, err)
+			return
+		}
+		webhook.Secret = ""
+		respondWithJSON(w, http.StatusOK, webhook)
+	case http.MethodDelete:
+		err = na.dbConnection.DeleteWebhook(userId, id)
+		if err != nil {
+			respondWithWebhookError(w, err)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, "deleted webhook with id "+vars["id"])
+	}
+}
+
+func (na *NotifyApp) getWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := currentUserId(w, r)
+	if err != nil {
+		return
+	}
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, errors.New("invalid webhook id"))
+		return
+	}
+	deliveries, err := na.dbConnection.GetWebhookDeliveries(userId, id, r)
+	if err != nil {
+		respondWithWebhookError(w, err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, deliveries)
+}
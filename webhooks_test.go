@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"birthday/types"
+)
+
+func TestWebhooksRejectPrivateURLs(t *testing.T) {
+	app := newTestApp(t)
+	app.createUser(t, "ann@example.com", time.Date(1990, time.May, 1, 0, 0, 0, 0, time.UTC))
+	token := app.login(t, "ann@example.com")
+
+	for _, url := range []string{"http://127.0.0.1:8080/hook", "http://localhost/hook", "http://10.0.0.1/hook", "http://169.254.169.254/latest/meta-data", "http://[::1]/hook"} {
+		w := app.request(t, http.MethodPost, "/api/webhooks", token, types.WebhookRequest{URL: url})
+		expectStatus(t, w, http.StatusBadRequest)
+	}
+
+	w := app.request(t, http.MethodPost, "/api/webhooks", token, types.WebhookRequest{URL: "https://93.184.215.14/hook"})
+	expectStatus(t, w, http.StatusCreated)
+	path := "/api/webhooks/" + strconv.Itoa(decode[types.Webhook](t, w).ID)
+	for _, method := range []string{http.MethodPut, http.MethodPatch} {
+		w = app.request(t, method, path, token, types.WebhookRequest{URL: "http://192.168.0.1/hook"})
+		expectStatus(t, w, http.StatusBadRequest)
+	}
+}